@@ -0,0 +1,292 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sygmaprotocol/rpc-gateway/internal/proxy"
+	"github.com/sygmaprotocol/rpc-gateway/internal/rpcgateway"
+	"github.com/sygmaprotocol/rpc-gateway/internal/usage"
+)
+
+const (
+	defaultDrainTimeout = time.Second * 30
+	defaultUsageWindow  = time.Hour * 24
+)
+
+// GatewaysFunc returns the gateways currently served. Gateways are looked up
+// on every request as they are replaced when the configuration is reloaded.
+type GatewaysFunc func() []*rpcgateway.RPCGateway
+
+type Server struct {
+	server *http.Server
+}
+
+func (s *Server) Start() error {
+	return s.server.ListenAndServe()
+}
+
+func (s *Server) Stop() error {
+	return s.server.Close()
+}
+
+func NewServer(config Config, gateways GatewaysFunc, recorder *usage.Recorder) *Server {
+	return &Server{
+		server: &http.Server{
+			Handler:           NewHandler(config.Token, gateways, recorder),
+			Addr:              fmt.Sprintf(":%d", config.Port),
+			WriteTimeout:      defaultDrainTimeout + time.Second*15,
+			ReadTimeout:       time.Second * 15,
+			ReadHeaderTimeout: time.Second * 5,
+		},
+	}
+}
+
+type GatewayStatus struct {
+	Name      string                 `json:"name"`
+	Path      string                 `json:"path"`
+	Providers []proxy.ProviderStatus `json:"providers"`
+}
+
+type healthRequest struct {
+	Status string `json:"status"`
+}
+
+type priorityRequest struct {
+	Providers []string `json:"providers"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns the admin API. Every request must carry the token as a
+// bearer token. Usage can only be exported when recorder is not nil.
+func NewHandler(token string, gateways GatewaysFunc, recorder *usage.Recorder) http.Handler {
+	h := &handler{gateways: gateways, recorder: recorder}
+
+	r := chi.NewRouter()
+	r.Use(bearerAuth(token))
+
+	r.Get("/gateways", h.listGateways)
+	r.Route("/gateways/{gateway}", func(r chi.Router) {
+		r.Get("/", h.getGateway)
+		r.Put("/priority", h.setPriority)
+		r.Post("/providers/{provider}/cordon", h.cordon(true))
+		r.Post("/providers/{provider}/uncordon", h.cordon(false))
+		r.Post("/providers/{provider}/drain", h.drain)
+		r.Put("/providers/{provider}/health", h.setHealth)
+	})
+	r.Get("/usage", h.exportUsage)
+
+	return r
+}
+
+func bearerAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type handler struct {
+	gateways GatewaysFunc
+	recorder *usage.Recorder
+}
+
+func (h *handler) listGateways(w http.ResponseWriter, _ *http.Request) {
+	gateways := h.gateways()
+	statuses := make([]GatewayStatus, 0, len(gateways))
+
+	for _, gateway := range gateways {
+		statuses = append(statuses, gatewayStatus(gateway))
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (h *handler) getGateway(w http.ResponseWriter, r *http.Request) {
+	gateway, ok := h.gateway(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, gatewayStatus(gateway))
+}
+
+func (h *handler) setPriority(w http.ResponseWriter, r *http.Request) {
+	gateway, ok := h.gateway(w, r)
+	if !ok {
+		return
+	}
+
+	var req priorityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	h.apply(w, gateway, gateway.Proxy().SetPriority(req.Providers))
+}
+
+func (h *handler) cordon(cordoned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gateway, ok := h.gateway(w, r)
+		if !ok {
+			return
+		}
+
+		h.apply(w, gateway, gateway.Proxy().Cordon(chi.URLParam(r, "provider"), cordoned))
+	}
+}
+
+func (h *handler) drain(w http.ResponseWriter, r *http.Request) {
+	gateway, ok := h.gateway(w, r)
+	if !ok {
+		return
+	}
+
+	timeout := defaultDrainTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed > defaultDrainTimeout {
+			writeJSON(w, http.StatusBadRequest, errorResponse{
+				Error: fmt.Sprintf("timeout must be a duration of at most %s", defaultDrainTimeout),
+			})
+
+			return
+		}
+		timeout = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	err := gateway.Proxy().Drain(ctx, chi.URLParam(r, "provider"))
+	if err != nil && ctx.Err() != nil {
+		writeJSON(w, http.StatusGatewayTimeout, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	h.apply(w, gateway, err)
+}
+
+func (h *handler) setHealth(w http.ResponseWriter, r *http.Request) {
+	gateway, ok := h.gateway(w, r)
+	if !ok {
+		return
+	}
+
+	var req healthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	h.apply(w, gateway, gateway.Proxy().SetHealthOverride(chi.URLParam(r, "provider"), req.Status))
+}
+
+// exportUsage writes the usage of the tokens between the from and to
+// RFC 3339 times, defaulting to the last 24 hours, as JSON or, with
+// format=csv, as CSV.
+func (h *handler) exportUsage(w http.ResponseWriter, r *http.Request) {
+	if h.recorder == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "usage is only recorded when authentication is enabled"})
+
+		return
+	}
+
+	to, err := parseTime(r.URL.Query().Get("to"), time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "to must be an RFC 3339 time"})
+
+		return
+	}
+
+	from, err := parseTime(r.URL.Query().Get("from"), to.Add(-defaultUsageWindow))
+	if err != nil || !from.Before(to) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "from must be an RFC 3339 time before to"})
+
+		return
+	}
+
+	report := h.recorder.Export(from, to)
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, report)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusOK)
+		report.WriteCSV(w) // nolint:errcheck
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("unknown format %q", format)})
+	}
+}
+
+func parseTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// gateway looks up the gateway named in the URL by its path and writes a 404
+// if there is none.
+func (h *handler) gateway(w http.ResponseWriter, r *http.Request) (*rpcgateway.RPCGateway, bool) {
+	path := chi.URLParam(r, "gateway")
+
+	for _, gateway := range h.gateways() {
+		if gateway.Path() == path {
+			return gateway, true
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, errorResponse{Error: fmt.Sprintf("unknown gateway %q", path)})
+
+	return nil, false
+}
+
+// apply writes the resulting state of the gateway, or the error of the
+// operation.
+func (h *handler) apply(w http.ResponseWriter, gateway *rpcgateway.RPCGateway, err error) {
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, gatewayStatus(gateway))
+}
+
+func gatewayStatus(gateway *rpcgateway.RPCGateway) GatewayStatus {
+	return GatewayStatus{
+		Name:      gateway.Name(),
+		Path:      gateway.Path(),
+		Providers: gateway.Proxy().Status(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) // nolint:errcheck
+}
@@ -0,0 +1,181 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/proxy"
+	"github.com/sygmaprotocol/rpc-gateway/internal/rpcgateway"
+	"github.com/sygmaprotocol/rpc-gateway/internal/usage"
+	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+)
+
+const testToken = "secret"
+
+func createTestGateway(t *testing.T) *rpcgateway.RPCGateway {
+	t.Helper()
+
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)) // nolint:errcheck
+	}))
+	t.Cleanup(upstream.Close)
+
+	targets := make([]proxy.NodeProviderConfig, 0, 2)
+	for _, name := range []string{"A", "B"} {
+		targets = append(targets, proxy.NodeProviderConfig{
+			Name: name,
+			Connection: proxy.NodeProviderConnectionConfig{
+				HTTP: proxy.NodeProviderConnectionHTTPConfig{URL: upstream.URL},
+			},
+		})
+	}
+
+	gateway, err := rpcgateway.NewRPCGateway(rpcgateway.RPCGatewayConfig{
+		Name: "test",
+		Proxy: proxy.ProxyConfig{
+			Path:            "sepolia",
+			UpstreamTimeout: util.DurationUnmarshalled(time.Second),
+		},
+		Targets: targets,
+	}, chi.NewRouter())
+	assert.NoError(t, err)
+
+	return gateway
+}
+
+func doRequest(handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func decodeStatus(t *testing.T, rr *httptest.ResponseRecorder) GatewayStatus {
+	t.Helper()
+
+	var status GatewayStatus
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+
+	return status
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	gateway := createTestGateway(t)
+	handler := NewHandler(testToken, func() []*rpcgateway.RPCGateway {
+		return []*rpcgateway.RPCGateway{gateway}
+	}, nil)
+
+	assert.Equal(t, http.StatusUnauthorized, doRequest(handler, http.MethodGet, "/gateways", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(handler, http.MethodGet, "/gateways", "wrong", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(NewHandler("", nil, nil), http.MethodGet, "/gateways", "", "").Code)
+
+	rr := doRequest(handler, http.MethodGet, "/gateways", testToken, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var statuses []GatewayStatus
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, "sepolia", statuses[0].Path)
+	assert.Len(t, statuses[0].Providers, 2)
+}
+
+func TestAdminManageProviders(t *testing.T) {
+	gateway := createTestGateway(t)
+	handler := NewHandler(testToken, func() []*rpcgateway.RPCGateway {
+		return []*rpcgateway.RPCGateway{gateway}
+	}, nil)
+
+	assert.Equal(t, http.StatusNotFound,
+		doRequest(handler, http.MethodGet, "/gateways/mainnet", testToken, "").Code)
+	assert.Equal(t, http.StatusBadRequest,
+		doRequest(handler, http.MethodPost, "/gateways/sepolia/providers/C/cordon", testToken, "").Code)
+
+	rr := doRequest(handler, http.MethodPost, "/gateways/sepolia/providers/A/cordon", testToken, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, decodeStatus(t, rr).Providers[0].Cordoned)
+
+	rr = doRequest(handler, http.MethodPost, "/gateways/sepolia/providers/A/drain?timeout=1s", testToken, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = doRequest(handler, http.MethodPost, "/gateways/sepolia/providers/A/uncordon", testToken, "")
+	assert.False(t, decodeStatus(t, rr).Providers[0].Cordoned)
+
+	rr = doRequest(handler, http.MethodPut, "/gateways/sepolia/providers/B/health", testToken, `{"status":"unhealthy"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	provider := decodeStatus(t, rr).Providers[1]
+	assert.False(t, provider.Healthy)
+	assert.Equal(t, "unhealthy", provider.HealthOverride)
+
+	assert.Equal(t, http.StatusBadRequest,
+		doRequest(handler, http.MethodPut, "/gateways/sepolia/providers/B/health", testToken, `{"status":"sick"}`).Code)
+
+	rr = doRequest(handler, http.MethodPut, "/gateways/sepolia/providers/B/health", testToken, `{"status":"auto"}`)
+	provider = decodeStatus(t, rr).Providers[1]
+	assert.True(t, provider.Healthy)
+	assert.Empty(t, provider.HealthOverride)
+
+	rr = doRequest(handler, http.MethodPut, "/gateways/sepolia/priority", testToken, `{"providers":["B"]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	status := decodeStatus(t, rr)
+	assert.Equal(t, "B", status.Providers[0].Name)
+	assert.Equal(t, "A", status.Providers[1].Name)
+
+	assert.Equal(t, http.StatusBadRequest,
+		doRequest(handler, http.MethodPut, "/gateways/sepolia/priority", testToken, `{"providers":["B","B"]}`).Code)
+}
+
+func TestAdminExportUsage(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	recorder := usage.NewRecorder(usage.Config{})
+	handler := NewHandler(testToken, nil, recorder)
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`
+	req := httptest.NewRequest(http.MethodPost, "/sepolia", strings.NewReader(body))
+	// Usage is recorded for the route of the gateway the request was served by.
+	rctx := chi.NewRouteContext()
+	rctx.RoutePatterns = []string{"/sepolia"}
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w, done := recorder.Track(httptest.NewRecorder(), req, "Partner", []byte(body))
+	w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)) // nolint:errcheck
+	done()
+
+	rr := doRequest(handler, http.MethodGet, "/usage", testToken, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var report usage.Report
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Len(t, report.Usage, 1)
+	assert.Equal(t, uint64(1), report.Usage[0].Requests)
+
+	rr = doRequest(handler, http.MethodGet, "/usage?format=csv", testToken, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "Partner,sepolia,eth_chainId,1,0,0,")
+
+	// Usage outside of the window is not exported.
+	from := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	to := time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339)
+	rr = doRequest(handler, http.MethodGet, "/usage?from="+from+"&to="+to, testToken, "")
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Empty(t, report.Usage)
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(handler, http.MethodGet, "/usage?format=xml", testToken, "").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(handler, http.MethodGet, "/usage?from=yesterday", testToken, "").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(handler, http.MethodGet, "/usage?from="+to+"&to="+from, testToken, "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(NewHandler(testToken, nil, nil), http.MethodGet, "/usage", testToken, "").Code)
+}
@@ -0,0 +1,6 @@
+package admin
+
+type Config struct {
+	Port  uint   `json:"port"`
+	Token string `json:"-"`
+}
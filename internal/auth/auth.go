@@ -4,14 +4,36 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+	"github.com/sygmaprotocol/rpc-gateway/internal/middleware"
+	"github.com/sygmaprotocol/rpc-gateway/internal/usage"
 	"golang.org/x/time/rate"
 )
 
 type TokenInfo struct {
-	Name               string `json:"name"`
-	NumOfRequestPerSec int    `json:"numOfRequestPerSec"`
+	Name string `json:"name" yaml:"name"`
+	// NumOfRequestPerSec is the rate limit of the token in compute units, one
+	// per call unless configured otherwise.
+	NumOfRequestPerSec int `json:"numOfRequestPerSec" yaml:"numOfRequestPerSec"`
+	// DailyQuota and MonthlyQuota are the compute units the token can use
+	// per calendar day and month in UTC. Zero means no quota.
+	DailyQuota   int64 `json:"dailyQuota,omitempty" yaml:"dailyQuota,omitempty"`
+	MonthlyQuota int64 `json:"monthlyQuota,omitempty" yaml:"monthlyQuota,omitempty"`
+	// Gateways lists the paths of the gateways the token can access, e.g.
+	// mainnet. Empty allows every gateway.
+	Gateways []string `json:"gateways,omitempty" yaml:"gateways,omitempty"`
+	// Methods restricts the methods the token can call.
+	Methods middleware.MethodFilterConfig `json:"methods" yaml:"methods"`
+}
+
+// CanAccess reports whether the token can access the gateway served on path.
+func (t TokenInfo) CanAccess(path string) bool {
+	return len(t.Gateways) == 0 || slices.Contains(t.Gateways, strings.Trim(path, "/"))
 }
 
 // ContextKeyType custom type for the context key.
@@ -19,53 +41,213 @@ type ContextKeyType string
 
 const TokenInfoKey ContextKeyType = "tokeninfo"
 
+const (
+	errUnauthorized = "unauthorized: missing or invalid API key"
+	errForbidden    = "forbidden: the API key cannot access this gateway"
+	errRateLimited  = "rate limit exceeded"
+	errQuota        = "quota exceeded"
+	errParse        = "parse error"
+)
+
+// URLTokenAuth authenticates requests with a token in the last segment of
+// the URL path.
 func URLTokenAuth(tokenToName map[string]TokenInfo) func(next http.Handler) http.Handler {
-	limiters := make(map[string]*rate.Limiter)
-	for token, info := range tokenToName {
-		limiters[token] = rate.NewLimiter(rate.Limit(info.NumOfRequestPerSec), info.NumOfRequestPerSec)
+	extract, _ := ExtractKey(Config{Sources: []string{SourcePath}})
+	authenticate := TokenAuth(tokenToName)
+
+	return func(next http.Handler) http.Handler {
+		return extract(authenticate(next))
+	}
+}
+
+// TokenAuth authenticates requests with the API key moved to the request
+// context by ExtractKey and rate limits them per token.
+func TokenAuth(tokenToName map[string]TokenInfo) func(next http.Handler) http.Handler {
+	for _, info := range tokenToName {
 		fmt.Printf("Configured limiter for %s, allowed %d requests per second\n",
 			info.Name, info.NumOfRequestPerSec,
 		)
 	}
 
+	return StoreAuth(StaticStore(tokenToName), CostConfig{}, nil)
+}
+
+// StoreAuth is TokenAuth with the tokens looked up in store, which may change
+// over time. Rate limits follow the changes of the tokens. Requests for a
+// gateway the token cannot access are forbidden, as well as calls to methods
+// it is not allowed to call. Each call is charged its cost against the rate
+// limit and the quotas of the token, including the calls sent over a
+// websocket opened with it. The usage of tokens, including these calls, is
+// recorded by recorder, unless it is nil.
+func StoreAuth(store TokenStore, costs CostConfig, recorder *usage.Recorder) func(next http.Handler) http.Handler {
+	a := &tokenAuth{
+		costs:    costs,
+		limiters: newLimiters(),
+		quotas:   newQuotas(),
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			pathParts := strings.Split(r.URL.Path, "/")
-			if len(pathParts) < 2 {
-				w.WriteHeader(http.StatusUnauthorized)
+			token, _ := r.Context().Value(APIKeyKey).(string)
+			tInfo, validToken := store.Lookup(token)
+			if token == "" || !validToken {
+				jsonrpc.WriteRequestError(w, r, http.StatusUnauthorized, jsonrpc.CodeUnauthorized, errUnauthorized)
 
 				return
 			}
 
-			token := pathParts[len(pathParts)-1]
-			tInfo, validToken := tokenToName[token]
-			if !validToken {
-				w.WriteHeader(http.StatusUnauthorized)
+			// The body is decoded once here, so that the permissions and
+			// the cost apply to the calls of gzip encoded requests too.
+			body, err := jsonrpc.ReadBody(r)
+			if err != nil {
+				jsonrpc.WriteError(w, http.StatusBadRequest, nil, jsonrpc.CodeParseError, errParse)
 
 				return
 			}
 
-			limiter, exists := limiters[token]
-			if !exists {
-				w.WriteHeader(http.StatusInternalServerError)
+			if recorder != nil {
+				var done func()
+				w, done = recorder.Track(w, r, tInfo.Name, body)
+				defer done()
+			}
+
+			if !tInfo.CanAccess(r.URL.Path) {
+				jsonrpc.WriteError(w, http.StatusForbidden, body, jsonrpc.CodeForbidden, errForbidden)
 
 				return
 			}
 
-			if !limiter.Allow() {
-				w.WriteHeader(http.StatusTooManyRequests)
+			if statusCode, rpcErr := a.charge(w.Header(), token, tInfo, body); rpcErr != nil {
+				jsonrpc.WriteError(w, statusCode, body, rpcErr.Code, rpcErr.Message)
 
 				return
 			}
 
-			// Remove the token part from the path
-			r.URL.Path = strings.Join(pathParts[:len(pathParts)-1], "/")
-
 			// Add the user's name to the request context
 			ctx := context.WithValue(r.Context(), TokenInfoKey, tInfo)
+			// Messages sent over a websocket go through the same checks
+			// as the body, with the token as it is when they are sent.
+			path := r.URL.Path
+			ctx = jsonrpc.WithCharge(ctx, func(message []byte) *jsonrpc.Error {
+				info, ok := store.Lookup(token)
+				switch {
+				case !ok:
+					return &jsonrpc.Error{Code: jsonrpc.CodeUnauthorized, Message: errUnauthorized}
+				case !info.CanAccess(path):
+					return &jsonrpc.Error{Code: jsonrpc.CodeForbidden, Message: errForbidden}
+				}
+
+				requests, _, err := jsonrpc.ParseRequests(message)
+				if err != nil {
+					return &jsonrpc.Error{Code: jsonrpc.CodeParseError, Message: errParse}
+				}
+				for _, request := range requests {
+					if rpcErr := forbiddenMethod(info, request); rpcErr != nil {
+						return rpcErr
+					}
+				}
+
+				_, rpcErr := a.charge(http.Header{}, token, info, message)
+
+				return rpcErr
+			})
+			if recorder != nil {
+				ctx = jsonrpc.WithRecord(ctx, recorder.TrackMessage(r, tInfo.Name))
+			}
 			r = r.WithContext(ctx)
 
-			next.ServeHTTP(w, r)
+			if tInfo.Methods.IsEmpty() {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			jsonrpc.Filter(func(request jsonrpc.Request) *jsonrpc.Error {
+				return forbiddenMethod(tInfo, request)
+			}, next).ServeHTTP(w, r)
 		})
 	}
 }
+
+// tokenAuth holds the rate limits and the quotas used by the tokens.
+type tokenAuth struct {
+	costs    CostConfig
+	limiters *limiters
+	quotas   *quotas
+}
+
+// charge charges the cost of the calls of body to the token. It returns the
+// status and the error to answer with if one of its quotas or its rate limit
+// is exceeded. The quota headers are set either way.
+func (a *tokenAuth) charge(header http.Header, token string, info TokenInfo, body []byte) (int, *jsonrpc.Error) {
+	cost := a.costs.requestCost(body, info)
+
+	if !a.quotas.reserve(header, info, int64(cost)) {
+		return http.StatusTooManyRequests, &jsonrpc.Error{Code: jsonrpc.CodeQuotaExceeded, Message: errQuota}
+	}
+
+	if !charge(a.limiters.get(token, info.NumOfRequestPerSec), cost) {
+		a.quotas.refund(header, info, int64(cost))
+
+		return http.StatusTooManyRequests, &jsonrpc.Error{Code: jsonrpc.CodeRateLimited, Message: errRateLimited}
+	}
+
+	return 0, nil
+}
+
+// forbiddenMethod returns the error of calls to methods the token is not
+// allowed to call, or nil.
+func forbiddenMethod(info TokenInfo, request jsonrpc.Request) *jsonrpc.Error {
+	if info.Methods.Rule(request.Method) == "" {
+		return nil
+	}
+
+	return &jsonrpc.Error{
+		Code:    jsonrpc.CodeForbidden,
+		Message: fmt.Sprintf("forbidden: the API key cannot call %s", request.Method),
+	}
+}
+
+// charge takes cost tokens from the limiter. A request costing more than the
+// burst is allowed once the limiter is full, otherwise it would never be, and
+// the rest of its cost is taken as debt that delays the next requests.
+func charge(limiter *rate.Limiter, cost int) bool {
+	now := time.Now()
+	if !limiter.AllowN(now, min(cost, limiter.Burst())) {
+		return false
+	}
+
+	for debt := cost - limiter.Burst(); debt > 0; debt -= limiter.Burst() {
+		limiter.ReserveN(now, min(debt, limiter.Burst()))
+	}
+
+	return true
+}
+
+// limiters holds the rate limiter of each key, created on first use.
+type limiters struct {
+	byKey map[string]*rate.Limiter
+	mu    sync.Mutex
+}
+
+func newLimiters() *limiters {
+	return &limiters{byKey: make(map[string]*rate.Limiter)}
+}
+
+// get returns the limiter of the key, updated to the given rate if it
+// changed since the last request.
+func (l *limiters) get(key string, perSec int) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.byKey[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(perSec), perSec)
+		l.byKey[key] = limiter
+	} else if limiter.Burst() != perSec {
+		limiter.SetLimit(rate.Limit(perSec))
+		limiter.SetBurst(perSec)
+	}
+
+	return limiter
+}
@@ -1,10 +1,19 @@
 package auth
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+	"github.com/sygmaprotocol/rpc-gateway/internal/middleware"
 )
 
 func TestURLTokenAuth(t *testing.T) {
@@ -110,3 +119,327 @@ func TestURLTokenAuthRateLimit(t *testing.T) {
 		t.Errorf("Expected status %v after rate limit reset; got %v", http.StatusOK, rr.Code)
 	}
 }
+
+func TestURLTokenAuthJSONRPCErrors(t *testing.T) {
+	tokenMap := map[string]TokenInfo{"valid_token": {Name: "Test User", NumOfRequestPerSec: 1}}
+	handler := URLTokenAuth(tokenMap)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(url string) *httptest.ResponseRecorder {
+		body := strings.NewReader(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":"b","method":"eth_chainId"}]`)
+		req, _ := http.NewRequest("POST", url, body)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedCode   int
+	}{
+		{name: "Invalid token", url: "/some/path/invalid_token", expectedStatus: http.StatusUnauthorized, expectedCode: jsonrpc.CodeUnauthorized},
+		{name: "Valid token", url: "/some/path/valid_token", expectedStatus: http.StatusOK},
+		{name: "Rate limited", url: "/some/path/valid_token", expectedStatus: http.StatusTooManyRequests, expectedCode: jsonrpc.CodeRateLimited},
+	}
+
+	for _, tt := range tests {
+		rr := send(tt.url)
+		if rr.Code != tt.expectedStatus {
+			t.Fatalf("%s: expected status %v; got %v", tt.name, tt.expectedStatus, rr.Code)
+		}
+		if tt.expectedCode == 0 {
+			continue
+		}
+
+		var responses []jsonrpc.Response
+		if err := json.Unmarshal(rr.Body.Bytes(), &responses); err != nil {
+			t.Fatalf("%s: expected a JSON-RPC batch response: %v", tt.name, err)
+		}
+		if len(responses) != 2 || string(responses[0].ID) != `1` || string(responses[1].ID) != `"b"` {
+			t.Errorf("%s: expected responses echoing the request IDs; got %s", tt.name, rr.Body.String())
+		}
+		for _, response := range responses {
+			if response.Error == nil || response.Error.Code != tt.expectedCode {
+				t.Errorf("%s: expected error code %d; got %s", tt.name, tt.expectedCode, rr.Body.String())
+			}
+		}
+	}
+}
+
+func TestTokenAuthKeySources(t *testing.T) {
+	tokenMap := map[string]TokenInfo{"valid_token": {Name: "Test User", NumOfRequestPerSec: 100}}
+
+	tests := []struct {
+		name           string
+		config         Config
+		url            string
+		headers        map[string]string
+		expectedStatus int
+		expectedURI    string
+	}{
+		{
+			name:           "Bearer token",
+			url:            "/sepolia",
+			headers:        map[string]string{"Authorization": "Bearer valid_token"},
+			expectedStatus: http.StatusOK,
+			expectedURI:    "/sepolia",
+		},
+		{
+			name:           "Custom header",
+			url:            "/sepolia",
+			headers:        map[string]string{"X-API-Key": "valid_token"},
+			expectedStatus: http.StatusOK,
+			expectedURI:    "/sepolia",
+		},
+		{
+			name:           "Renamed header",
+			config:         Config{Header: "X-Token"},
+			url:            "/sepolia",
+			headers:        map[string]string{"X-Token": "valid_token"},
+			expectedStatus: http.StatusOK,
+			expectedURI:    "/sepolia",
+		},
+		{
+			name:           "Query parameter",
+			url:            "/sepolia?apiKey=valid_token&foo=bar",
+			expectedStatus: http.StatusOK,
+			expectedURI:    "/sepolia?foo=bar",
+		},
+		{
+			name:           "Path",
+			url:            "/sepolia/valid_token",
+			expectedStatus: http.StatusOK,
+			expectedURI:    "/sepolia",
+		},
+		{
+			name:           "Header takes precedence over path",
+			url:            "/sepolia/mainnet",
+			headers:        map[string]string{"X-API-Key": "valid_token"},
+			expectedStatus: http.StatusOK,
+			expectedURI:    "/sepolia/mainnet",
+		},
+		{
+			name:           "Invalid header",
+			url:            "/sepolia",
+			headers:        map[string]string{"X-API-Key": "invalid_token"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Disabled source",
+			config:         Config{Sources: []string{SourceBearer}},
+			url:            "/sepolia?apiKey=valid_token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extract, err := ExtractKey(tt.config)
+			if err != nil {
+				t.Fatalf("could not create middleware: %v", err)
+			}
+
+			var forwarded *http.Request
+			handler := extract(TokenAuth(tokenMap)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r
+				w.WriteHeader(http.StatusOK)
+			})))
+
+			req := httptest.NewRequest("POST", tt.url, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expected status %v; got %v", tt.expectedStatus, rr.Code)
+			}
+			if forwarded == nil {
+				return
+			}
+			if forwarded.RequestURI != tt.expectedURI || forwarded.URL.RequestURI() != tt.expectedURI {
+				t.Errorf("expected request URI %q; got %q and %q", tt.expectedURI, forwarded.RequestURI, forwarded.URL.RequestURI())
+			}
+			for name := range tt.headers {
+				if forwarded.Header.Get(name) != "" {
+					t.Errorf("expected the %s header to be removed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractKeyUnknownSource(t *testing.T) {
+	if _, err := ExtractKey(Config{Sources: []string{"cookie"}}); err == nil {
+		t.Errorf("expected an error for an unknown source")
+	}
+}
+
+func TestStoreAuthPermissions(t *testing.T) {
+	store := StaticStore{
+		"partner_token": {
+			Name:               "Partner",
+			NumOfRequestPerSec: 100,
+			Gateways:           []string{"mainnet"},
+			Methods:            middleware.MethodFilterConfig{Allow: []string{"eth_*"}, Deny: []string{"eth_sendRawTransaction"}},
+		},
+		"internal_token": {Name: "Internal", NumOfRequestPerSec: 100},
+	}
+
+	var forwarded string
+	handler := StoreAuth(store, CostConfig{}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"}]`)) // nolint:errcheck
+	}))
+
+	tests := []struct {
+		name           string
+		token          string
+		path           string
+		body           string
+		expectedStatus int
+		expectedCodes  []int
+		forwarded      bool
+		gzip           bool
+	}{
+		{
+			name:           "Unrestricted token",
+			token:          "internal_token",
+			path:           "/sepolia",
+			body:           `[{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction"}]`,
+			expectedStatus: http.StatusOK,
+			forwarded:      true,
+		},
+		{
+			name:           "Gateway not allowed",
+			token:          "partner_token",
+			path:           "/sepolia",
+			body:           `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}]`,
+			expectedStatus: http.StatusForbidden,
+			expectedCodes:  []int{jsonrpc.CodeForbidden},
+		},
+		{
+			name:           "Allowed method",
+			token:          "partner_token",
+			path:           "/mainnet",
+			body:           `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}]`,
+			expectedStatus: http.StatusOK,
+			forwarded:      true,
+		},
+		{
+			name:           "Method not allowed",
+			token:          "partner_token",
+			path:           "/mainnet",
+			body:           `[{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction"},{"jsonrpc":"2.0","id":2,"method":"eth_sendRawTransaction"}]`,
+			expectedStatus: http.StatusOK,
+			expectedCodes:  []int{jsonrpc.CodeForbidden, jsonrpc.CodeForbidden},
+		},
+		{
+			name:           "Gzip encoded method not allowed",
+			token:          "partner_token",
+			path:           "/mainnet",
+			body:           `[{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction"}]`,
+			expectedStatus: http.StatusOK,
+			expectedCodes:  []int{jsonrpc.CodeForbidden},
+			gzip:           true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = ""
+			var body io.Reader = strings.NewReader(tt.body)
+			if tt.gzip {
+				body = gzipBody(t, tt.body)
+			}
+			req := httptest.NewRequest("POST", tt.path, body)
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			req = req.WithContext(context.WithValue(req.Context(), APIKeyKey, tt.token))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expected status %v; got %v", tt.expectedStatus, rr.Code)
+			}
+			if (forwarded != "") != tt.forwarded {
+				t.Errorf("expected forwarded %v; got %q", tt.forwarded, forwarded)
+			}
+			if len(tt.expectedCodes) == 0 {
+				return
+			}
+
+			var responses []jsonrpc.Response
+			if err := json.Unmarshal(rr.Body.Bytes(), &responses); err != nil {
+				t.Fatalf("expected a JSON-RPC batch response: %v", err)
+			}
+			if len(responses) != len(tt.expectedCodes) {
+				t.Fatalf("expected %d responses; got %s", len(tt.expectedCodes), rr.Body.String())
+			}
+			for i, response := range responses {
+				if response.Error == nil || response.Error.Code != tt.expectedCodes[i] {
+					t.Errorf("expected error code %d; got %s", tt.expectedCodes[i], rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func gzipBody(t *testing.T, body string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	g := gzip.NewWriter(&buf)
+	if _, err := g.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestStoreAuthChargesMessages(t *testing.T) {
+	store := StaticStore{
+		"token": {
+			Name:               "Partner",
+			NumOfRequestPerSec: 3,
+			Methods:            middleware.MethodFilterConfig{Deny: []string{"debug_*"}},
+		},
+	}
+
+	var charge jsonrpc.ChargeFunc
+	handler := StoreAuth(store, CostConfig{}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		charge = jsonrpc.ChargeFromContext(r.Context())
+	}))
+
+	// The upgrade request is charged as a call.
+	req := httptest.NewRequest(http.MethodGet, "/mainnet", nil)
+	req = req.WithContext(context.WithValue(req.Context(), APIKeyKey, "token"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if charge == nil {
+		t.Fatal("Expected the request to be served")
+	}
+
+	if rpcErr := charge([]byte(`{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction"}`)); rpcErr == nil || rpcErr.Code != jsonrpc.CodeForbidden {
+		t.Errorf("Expected a forbidden method to be rejected, got %v", rpcErr)
+	}
+
+	if rpcErr := charge([]byte(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`)); rpcErr != nil {
+		t.Errorf("Expected the batch to be allowed, got %v", rpcErr)
+	}
+
+	if rpcErr := charge([]byte(`{"jsonrpc":"2.0","id":3,"method":"eth_chainId"}`)); rpcErr == nil || rpcErr.Code != jsonrpc.CodeRateLimited {
+		t.Errorf("Expected the rate limit to apply to messages, got %v", rpcErr)
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sources of API keys.
+const (
+	SourcePath   = "path"
+	SourceBearer = "bearer"
+	SourceHeader = "header"
+	SourceQuery  = "query"
+)
+
+const (
+	DefaultHeader     = "X-API-Key"
+	DefaultQueryParam = "apiKey"
+)
+
+// APIKeyKey is the context key of the API key extracted from the request.
+const APIKeyKey ContextKeyType = "apikey"
+
+// Config selects where API keys are looked for and how calls are charged.
+type Config struct {
+	// Sources lists the places API keys are looked for, in order: bearer for
+	// the Authorization header, header for the custom header, query for the
+	// query parameter and path for the last segment of the URL path.
+	// Defaults to all of them, in that order.
+	Sources []string `json:"sources"`
+	// Header is the name of the custom header. Defaults to X-API-Key.
+	Header string `json:"header"`
+	// QueryParam is the name of the query parameter. Defaults to apiKey.
+	QueryParam string `json:"queryParam"`
+	// Costs is the cost of the JSON-RPC methods charged to tokens.
+	Costs CostConfig `json:"costs"`
+}
+
+func (c Config) withDefaults() Config {
+	if len(c.Sources) == 0 {
+		c.Sources = []string{SourceBearer, SourceHeader, SourceQuery, SourcePath}
+	}
+	if c.Header == "" {
+		c.Header = DefaultHeader
+	}
+	if c.QueryParam == "" {
+		c.QueryParam = DefaultQueryParam
+	}
+
+	return c
+}
+
+// HeaderName returns the custom header carrying API keys, to be redacted
+// from logs.
+func (c Config) HeaderName() string {
+	return c.withDefaults().Header
+}
+
+// ExtractKey returns a middleware moving the API key from the request to its
+// context, where TokenAuth looks for it. The key is removed from the headers,
+// the query and the path, so that it is neither logged nor forwarded to the
+// providers. It has to run before the request logger.
+func ExtractKey(config Config) (func(http.Handler) http.Handler, error) {
+	config = config.withDefaults()
+
+	for _, source := range config.Sources {
+		switch source {
+		case SourcePath, SourceBearer, SourceHeader, SourceQuery:
+		default:
+			return nil, fmt.Errorf("unknown API key source %q", source)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := extractKey(r, config)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), APIKeyKey, key)))
+		})
+	}, nil
+}
+
+// extractKey returns the key from the first source carrying one. Keys found
+// in the headers or the query are removed even when another source takes
+// precedence; the last path segment is only removed when it is the key.
+func extractKey(r *http.Request, config Config) string {
+	var key string
+
+	for _, source := range config.Sources {
+		var found string
+
+		switch source {
+		case SourceBearer:
+			if value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				found = strings.TrimSpace(value)
+				r.Header.Del("Authorization")
+			}
+		case SourceHeader:
+			found = r.Header.Get(config.Header)
+			r.Header.Del(config.Header)
+		case SourceQuery:
+			query := r.URL.Query()
+			if query.Has(config.QueryParam) {
+				found = query.Get(config.QueryParam)
+				query.Del(config.QueryParam)
+				r.URL.RawQuery = query.Encode()
+			}
+		case SourcePath:
+			if key == "" {
+				found = cutPathKey(r)
+			}
+		}
+
+		if key == "" {
+			key = found
+		}
+	}
+
+	r.RequestURI = r.URL.RequestURI()
+
+	return key
+}
+
+// cutPathKey removes the last segment of the path and returns it.
+func cutPathKey(r *http.Request) string {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+
+	r.URL.Path = strings.Join(parts[:len(parts)-1], "/")
+	r.URL.RawPath = ""
+
+	return parts[len(parts)-1]
+}
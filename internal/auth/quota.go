@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+// Headers reporting the quotas of a token, only set for the windows the token
+// has a quota for. Reset is the Unix time the window ends at.
+const (
+	HeaderQuotaDailyLimit       = "X-Quota-Daily-Limit"
+	HeaderQuotaDailyRemaining   = "X-Quota-Daily-Remaining"
+	HeaderQuotaDailyReset       = "X-Quota-Daily-Reset"
+	HeaderQuotaMonthlyLimit     = "X-Quota-Monthly-Limit"
+	HeaderQuotaMonthlyRemaining = "X-Quota-Monthly-Remaining"
+	HeaderQuotaMonthlyReset     = "X-Quota-Monthly-Reset"
+)
+
+// CostConfig is the cost in compute units of the JSON-RPC methods, charged
+// for each call of a batch against the rate limit and the quotas of tokens.
+type CostConfig struct {
+	// Default is the cost of the methods missing from Methods, and of
+	// requests that are not JSON-RPC. Defaults to 1.
+	Default int `json:"default"`
+	// Methods maps method names, or prefixes ending with a wildcard like
+	// debug_*, to their cost, zero making them free. Names take precedence
+	// over prefixes and longer prefixes over shorter ones.
+	Methods map[string]int `json:"methods"`
+}
+
+// Cost returns the cost of a call to the method.
+func (c CostConfig) Cost(method string) int {
+	if cost, ok := c.Methods[method]; ok {
+		return max(cost, 0)
+	}
+
+	matched := ""
+	for pattern := range c.Methods {
+		if strings.HasSuffix(pattern, "*") && len(pattern) > len(matched) && jsonrpc.MatchMethod(pattern, method) {
+			matched = pattern
+		}
+	}
+	if matched != "" {
+		return max(c.Methods[matched], 0)
+	}
+
+	if c.Default <= 0 {
+		return 1
+	}
+
+	return c.Default
+}
+
+// requestCost returns the cost of the calls of the body the token is allowed
+// to make. The body must be decoded, otherwise compressed batches would be
+// charged as a single call.
+func (c CostConfig) requestCost(body []byte, info TokenInfo) int {
+	requests, _, err := jsonrpc.ParseRequests(body)
+	if err != nil {
+		return c.Cost("")
+	}
+
+	cost := 0
+	for _, request := range requests {
+		if info.Methods.Rule(request.Method) == "" {
+			cost += c.Cost(request.Method)
+		}
+	}
+
+	return cost
+}
+
+type quotaUsage struct {
+	day     time.Time
+	month   time.Time
+	daily   int64
+	monthly int64
+}
+
+// quotas tracks the compute units used by each token, by name so that
+// rotating the key of a token keeps its usage, over calendar days and months
+// in UTC. Usage is kept in memory and starts over when the gateway restarts.
+type quotas struct {
+	byName map[string]*quotaUsage
+	mu     sync.Mutex
+	now    func() time.Time
+}
+
+func newQuotas() *quotas {
+	return &quotas{
+		byName: make(map[string]*quotaUsage),
+		now:    time.Now,
+	}
+}
+
+// usage returns the usage of the token within the current windows. The lock
+// must be held.
+func (q *quotas) usage(name string) *quotaUsage {
+	now := q.now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	usage, ok := q.byName[name]
+	if !ok {
+		usage = &quotaUsage{}
+		q.byName[name] = usage
+	}
+
+	if !usage.day.Equal(day) {
+		usage.day, usage.daily = day, 0
+	}
+	if !usage.month.Equal(month) {
+		usage.month, usage.monthly = month, 0
+	}
+
+	return usage
+}
+
+// reserve charges the cost to the token unless it would exceed one of its
+// quotas. It writes the quota headers either way.
+func (q *quotas) reserve(header http.Header, info TokenInfo, cost int64) bool {
+	if info.DailyQuota <= 0 && info.MonthlyQuota <= 0 {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage := q.usage(info.Name)
+	allowed := (info.DailyQuota <= 0 || usage.daily+cost <= info.DailyQuota) &&
+		(info.MonthlyQuota <= 0 || usage.monthly+cost <= info.MonthlyQuota)
+	if allowed {
+		usage.daily += cost
+		usage.monthly += cost
+	}
+
+	if info.DailyQuota > 0 {
+		setQuotaHeaders(header, HeaderQuotaDailyLimit, HeaderQuotaDailyRemaining, HeaderQuotaDailyReset,
+			info.DailyQuota, usage.daily, usage.day.AddDate(0, 0, 1))
+	}
+	if info.MonthlyQuota > 0 {
+		setQuotaHeaders(header, HeaderQuotaMonthlyLimit, HeaderQuotaMonthlyRemaining, HeaderQuotaMonthlyReset,
+			info.MonthlyQuota, usage.monthly, usage.month.AddDate(0, 1, 0))
+	}
+
+	return allowed
+}
+
+// refund gives back the cost of a request that was not served after all.
+func (q *quotas) refund(header http.Header, info TokenInfo, cost int64) {
+	if info.DailyQuota <= 0 && info.MonthlyQuota <= 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage := q.usage(info.Name)
+	usage.daily = max(usage.daily-cost, 0)
+	usage.monthly = max(usage.monthly-cost, 0)
+
+	if info.DailyQuota > 0 {
+		header.Set(HeaderQuotaDailyRemaining, strconv.FormatInt(max(info.DailyQuota-usage.daily, 0), 10))
+	}
+	if info.MonthlyQuota > 0 {
+		header.Set(HeaderQuotaMonthlyRemaining, strconv.FormatInt(max(info.MonthlyQuota-usage.monthly, 0), 10))
+	}
+}
+
+func setQuotaHeaders(header http.Header, limitHeader, remainingHeader, resetHeader string, limit, used int64, reset time.Time) {
+	header.Set(limitHeader, strconv.FormatInt(limit, 10))
+	header.Set(remainingHeader, strconv.FormatInt(max(limit-used, 0), 10))
+	header.Set(resetHeader, strconv.FormatInt(reset.Unix(), 10))
+}
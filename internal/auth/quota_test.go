@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+func TestCostConfig(t *testing.T) {
+	costs := CostConfig{
+		Default: 2,
+		Methods: map[string]int{
+			"eth_getLogs":           75,
+			"debug_*":               100,
+			"debug_traceCall":       50,
+			"debug_traceBlock*":     200,
+			"eth_blockNumber":       0,
+			"trace_*":               -1,
+			"eth_getBlockByNumber":  16,
+			"eth_getBlockByNumber*": 1000,
+		},
+	}
+
+	tests := map[string]int{
+		"eth_chainId":                2,
+		"eth_getLogs":                75,
+		"debug_traceTransaction":     100,
+		"debug_traceCall":            50,
+		"debug_traceBlockByNumber":   200,
+		"eth_blockNumber":            0,
+		"trace_block":                0,
+		"eth_getBlockByNumber":       16,
+		"eth_getBlockByNumberOrHash": 1000,
+	}
+
+	for method, expected := range tests {
+		if cost := costs.Cost(method); cost != expected {
+			t.Errorf("%s: expected cost %d; got %d", method, expected, cost)
+		}
+	}
+
+	body := []byte(`[{"jsonrpc":"2.0","id":1,"method":"eth_getLogs"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"},{"jsonrpc":"2.0","method":"debug_traceCall"}]`)
+	if cost := costs.requestCost(body, TokenInfo{}); cost != 127 {
+		t.Errorf("expected batch cost 127; got %d", cost)
+	}
+	if cost := costs.requestCost([]byte("not json"), TokenInfo{}); cost != 2 {
+		t.Errorf("expected default cost for an invalid body; got %d", cost)
+	}
+}
+
+func TestQuotaWindows(t *testing.T) {
+	now := time.Date(2026, 1, 30, 23, 0, 0, 0, time.UTC)
+	q := newQuotas()
+	q.now = func() time.Time { return now }
+	info := TokenInfo{Name: "Test User", DailyQuota: 10, MonthlyQuota: 15}
+
+	rr := httptest.NewRecorder()
+	if !q.reserve(rr.Header(), info, 10) {
+		t.Fatalf("expected the daily quota to be available")
+	}
+	if q.reserve(rr.Header(), info, 1) {
+		t.Fatalf("expected the daily quota to be exhausted")
+	}
+	if rr.Header().Get(HeaderQuotaDailyRemaining) != "0" || rr.Header().Get(HeaderQuotaMonthlyRemaining) != "5" {
+		t.Errorf("unexpected quota headers: %v", rr.Header())
+	}
+	if reset := rr.Header().Get(HeaderQuotaDailyReset); reset != "1769817600" {
+		t.Errorf("expected the daily quota to reset at midnight; got %s", reset)
+	}
+
+	// Next day, same month: the monthly quota is the limit.
+	now = now.Add(2 * time.Hour)
+	if !q.reserve(http.Header{}, info, 5) {
+		t.Fatalf("expected the quota to be available on the next day")
+	}
+	if q.reserve(http.Header{}, info, 1) {
+		t.Fatalf("expected the monthly quota to be exhausted")
+	}
+
+	q.refund(http.Header{}, info, 1)
+	if !q.reserve(http.Header{}, info, 1) {
+		t.Fatalf("expected the refunded units to be available")
+	}
+
+	// Next month.
+	now = time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	if !q.reserve(http.Header{}, info, 10) {
+		t.Errorf("expected the quotas to be reset on the next month")
+	}
+}
+
+func TestStoreAuthQuota(t *testing.T) {
+	store := StaticStore{"valid_token": {Name: "Test User", NumOfRequestPerSec: 100, DailyQuota: 100}}
+	costs := CostConfig{Methods: map[string]int{"eth_getLogs": 40}}
+	handler := StoreAuth(store, costs, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/sepolia", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), APIKeyKey, "valid_token"))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	// Each call of the batch is charged.
+	rr := send(`[{"jsonrpc":"2.0","id":1,"method":"eth_getLogs"},{"jsonrpc":"2.0","id":2,"method":"eth_getLogs"},{"jsonrpc":"2.0","id":3,"method":"eth_chainId"}]`)
+	if rr.Code != http.StatusOK || rr.Header().Get(HeaderQuotaDailyRemaining) != "19" {
+		t.Fatalf("expected the batch to be served with 19 units left; got %v with %v", rr.Code, rr.Header())
+	}
+
+	rr = send(`{"jsonrpc":"2.0","id":4,"method":"eth_getLogs"}`)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %v; got %v", http.StatusTooManyRequests, rr.Code)
+	}
+
+	var response jsonrpc.Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("expected a JSON-RPC response: %v", err)
+	}
+	if response.Error == nil || response.Error.Code != jsonrpc.CodeQuotaExceeded || string(response.ID) != "4" {
+		t.Errorf("expected a quota exceeded error; got %s", rr.Body.String())
+	}
+
+	// Cheaper calls still fit in the quota.
+	if rr := send(`{"jsonrpc":"2.0","id":5,"method":"eth_chainId"}`); rr.Code != http.StatusOK {
+		t.Errorf("expected status %v; got %v", http.StatusOK, rr.Code)
+	}
+}
+
+func TestStoreAuthGzipCost(t *testing.T) {
+	store := StaticStore{"valid_token": {Name: "Test User", NumOfRequestPerSec: 100, DailyQuota: 100}}
+	costs := CostConfig{Methods: map[string]int{"eth_getLogs": 40}}
+	handler := StoreAuth(store, costs, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// The calls of gzip encoded batches are charged like the others.
+	body := `[{"jsonrpc":"2.0","id":1,"method":"eth_getLogs"},{"jsonrpc":"2.0","id":2,"method":"eth_getLogs"}]`
+	req := httptest.NewRequest("POST", "/sepolia", gzipBody(t, body))
+	req.Header.Set("Content-Encoding", "gzip")
+	req = req.WithContext(context.WithValue(req.Context(), APIKeyKey, "valid_token"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get(HeaderQuotaDailyRemaining) != "20" {
+		t.Fatalf("expected the batch to be served with 20 units left; got %v with %v", rr.Code, rr.Header())
+	}
+
+	// Bodies that are not gzip are rejected before being charged.
+	req = httptest.NewRequest("POST", "/sepolia", strings.NewReader(body))
+	req.Header.Set("Content-Encoding", "gzip")
+	req = req.WithContext(context.WithValue(req.Context(), APIKeyKey, "valid_token"))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest || rr.Header().Get(HeaderQuotaDailyRemaining) != "" {
+		t.Errorf("expected status %v without charge; got %v with %v", http.StatusBadRequest, rr.Code, rr.Header())
+	}
+}
+
+func TestStoreAuthOversizedBatch(t *testing.T) {
+	store := StaticStore{"token": {Name: "Test User", NumOfRequestPerSec: 10}}
+	handler := StoreAuth(store, CostConfig{}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), APIKeyKey, "token"))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	calls := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		calls = append(calls, `{"jsonrpc":"2.0","id":`+strconv.Itoa(i)+`,"method":"eth_chainId"}`)
+	}
+
+	if rr := send("[" + strings.Join(calls, ",") + "]"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the batch to be served, got %d", rr.Code)
+	}
+
+	// The batch costs ten seconds of the rate limit, not one.
+	time.Sleep(time.Second)
+	if rr := send(calls[0]); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %v after an oversized batch; got %v", http.StatusTooManyRequests, rr.Code)
+	}
+}
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TokenStore resolves API keys to the information of their token.
+type TokenStore interface {
+	Lookup(key string) (TokenInfo, bool)
+}
+
+// StaticStore is a fixed set of plaintext tokens.
+type StaticStore map[string]TokenInfo
+
+func (s StaticStore) Lookup(key string) (TokenInfo, bool) {
+	info, ok := s[key]
+
+	return info, ok
+}
+
+const hashPrefix = "sha256:"
+
+// StoredToken is a token of a token file. The key itself is not stored, only
+// its salted hash as produced by HashToken.
+type StoredToken struct {
+	TokenInfo `yaml:",inline"`
+	Hash      string `json:"hash" yaml:"hash"`
+	// ExpiresAt is when the token stops being accepted. Tokens without it
+	// never expire.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" yaml:"expiresAt,omitempty"`
+	Disabled  bool       `json:"disabled" yaml:"disabled"`
+}
+
+type tokenFile struct {
+	Tokens []StoredToken `json:"tokens" yaml:"tokens"`
+}
+
+type hashedToken struct {
+	StoredToken
+	salt []byte
+	sum  []byte
+}
+
+// FileStore is a set of hashed tokens loaded from a JSON or YAML file, YAML
+// being used for files with a .yaml or .yml extension.
+type FileStore struct {
+	path    string
+	tokens  atomic.Pointer[[]hashedToken]
+	modTime time.Time
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+// NewFileStore loads the tokens from the file at path.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path: path,
+		now:  time.Now,
+	}
+
+	if err := s.Load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Lookup returns the token matching the key, unless it is disabled or
+// expired.
+func (s *FileStore) Lookup(key string) (TokenInfo, bool) {
+	for _, token := range *s.tokens.Load() {
+		if subtle.ConstantTimeCompare(hashKey(token.salt, key), token.sum) != 1 {
+			continue
+		}
+
+		if token.Disabled || (token.ExpiresAt != nil && !s.now().Before(*token.ExpiresAt)) {
+			return TokenInfo{}, false
+		}
+
+		return token.TokenInfo, true
+	}
+
+	return TokenInfo{}, false
+}
+
+// Load reads the token file and replaces the tokens in use. On error the
+// previous tokens are kept.
+func (s *FileStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("cannot read token file: %w", err)
+	}
+	// A file failing to load is not retried until it changes again.
+	s.modTime = info.ModTime()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("cannot read token file: %w", err)
+	}
+
+	var file tokenFile
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &file)
+	default:
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot parse token file: %w", err)
+	}
+
+	tokens := make([]hashedToken, 0, len(file.Tokens))
+	for i, token := range file.Tokens {
+		if token.Name == "" {
+			return fmt.Errorf("token %d has no name", i)
+		}
+		if token.NumOfRequestPerSec <= 0 {
+			return fmt.Errorf("token %s: numOfRequestPerSec must be a positive number", token.Name)
+		}
+
+		salt, sum, err := parseHash(token.Hash)
+		if err != nil {
+			return fmt.Errorf("token %s: %w", token.Name, err)
+		}
+
+		tokens = append(tokens, hashedToken{StoredToken: token, salt: salt, sum: sum})
+	}
+
+	s.tokens.Store(&tokens)
+
+	return nil
+}
+
+// Watch reloads the token file when it changes on disk, checking every
+// interval, until ctx is done.
+func (s *FileStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+
+			fmt.Println("Reloading tokens: token file changed")
+			if err := s.Load(); err != nil {
+				fmt.Fprintf(os.Stderr, "error reloading tokens, keeping the current ones: %v\n", err)
+			}
+		}
+	}
+}
+
+func (s *FileStore) changed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+
+	return err != nil || !info.ModTime().Equal(s.modTime)
+}
+
+// HashToken returns the salted hash of a key to be stored in a token file.
+func HashToken(key string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return hashPrefix + hex.EncodeToString(salt) + ":" + hex.EncodeToString(hashKey(salt, key)), nil
+}
+
+// GenerateToken returns a new random key.
+func GenerateToken() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+func hashKey(salt []byte, key string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(key))
+
+	return h.Sum(nil)
+}
+
+func parseHash(hash string) ([]byte, []byte, error) {
+	encoded, ok := strings.CutPrefix(hash, hashPrefix)
+	if !ok {
+		return nil, nil, fmt.Errorf("hash must start with %q", hashPrefix)
+	}
+
+	encodedSalt, encodedSum, ok := strings.Cut(encoded, ":")
+	if !ok {
+		return nil, nil, fmt.Errorf("hash must be formatted as %s<salt>:<sum>", hashPrefix)
+	}
+
+	salt, err := hex.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid hash salt: %w", err)
+	}
+
+	sum, err := hex.DecodeString(encodedSum)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, nil, fmt.Errorf("invalid hash sum")
+	}
+
+	return salt, sum, nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTokenFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("could not write token file: %v", err)
+	}
+}
+
+func hashOf(t *testing.T, key string) string {
+	t.Helper()
+
+	hash, err := HashToken(key)
+	if err != nil {
+		t.Fatalf("could not hash token: %v", err)
+	}
+
+	return hash
+}
+
+func TestFileStore(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := map[string]string{
+		"tokens.json": fmt.Sprintf(`{"tokens": [
+			{"name": "active", "hash": %q, "numOfRequestPerSec": 10, "gateways": ["mainnet"], "methods": {"allow": ["eth_*"]}},
+			{"name": "future", "hash": %q, "numOfRequestPerSec": 10, "expiresAt": "2026-06-01T00:00:00Z"},
+			{"name": "expired", "hash": %q, "numOfRequestPerSec": 10, "expiresAt": "2025-06-01T00:00:00Z"},
+			{"name": "disabled", "hash": %q, "numOfRequestPerSec": 10, "disabled": true}
+		]}`, hashOf(t, "active_key"), hashOf(t, "future_key"), hashOf(t, "expired_key"), hashOf(t, "disabled_key")),
+		"tokens.yaml": fmt.Sprintf(`tokens:
+  - name: active
+    hash: %s
+    numOfRequestPerSec: 10
+    gateways: [mainnet]
+    methods:
+      allow: [eth_*]
+  - name: future
+    hash: %s
+    numOfRequestPerSec: 10
+    expiresAt: 2026-06-01T00:00:00Z
+  - name: expired
+    hash: %s
+    numOfRequestPerSec: 10
+    expiresAt: 2025-06-01T00:00:00Z
+  - name: disabled
+    hash: %s
+    numOfRequestPerSec: 10
+    disabled: true
+`, hashOf(t, "active_key"), hashOf(t, "future_key"), hashOf(t, "expired_key"), hashOf(t, "disabled_key")),
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			writeTokenFile(t, path, content)
+
+			store, err := NewFileStore(path)
+			if err != nil {
+				t.Fatalf("could not load token file: %v", err)
+			}
+			store.now = func() time.Time { return now }
+
+			tests := []struct {
+				key      string
+				expected string
+			}{
+				{key: "active_key", expected: "active"},
+				{key: "future_key", expected: "future"},
+				{key: "expired_key"},
+				{key: "disabled_key"},
+				{key: "unknown_key"},
+				{key: ""},
+			}
+
+			for _, tt := range tests {
+				info, ok := store.Lookup(tt.key)
+				if ok != (tt.expected != "") || info.Name != tt.expected {
+					t.Errorf("%q: expected token %q; got %q (%v)", tt.key, tt.expected, info.Name, ok)
+				}
+			}
+
+			info, _ := store.Lookup("active_key")
+			if !info.CanAccess("/mainnet") || info.CanAccess("/sepolia") || info.Methods.Rule("debug_traceCall") == "" {
+				t.Errorf("expected the permissions of the token to be loaded; got %+v", info)
+			}
+		})
+	}
+}
+
+func TestFileStoreInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "Malformed", content: `{"tokens": [`},
+		{name: "Plaintext key", content: `{"tokens": [{"name": "a", "hash": "key", "numOfRequestPerSec": 1}]}`},
+		{name: "Truncated hash", content: `{"tokens": [{"name": "a", "hash": "sha256:00:abcd", "numOfRequestPerSec": 1}]}`},
+		{name: "Missing rate", content: fmt.Sprintf(`{"tokens": [{"name": "a", "hash": %q}]}`, hashOf(t, "key"))},
+		{name: "Missing name", content: fmt.Sprintf(`{"tokens": [{"hash": %q, "numOfRequestPerSec": 1}]}`, hashOf(t, "key"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.json")
+			writeTokenFile(t, path, tt.content)
+
+			if _, err := NewFileStore(path); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	writeTokenFile(t, path, fmt.Sprintf(`{"tokens": [{"name": "old", "hash": %q, "numOfRequestPerSec": 1}]}`, hashOf(t, "old_key")))
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("could not load token file: %v", err)
+	}
+
+	handler := StoreAuth(store, CostConfig{}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(key string) int {
+		req := httptest.NewRequest("POST", "/sepolia", nil)
+		req = req.WithContext(context.WithValue(req.Context(), APIKeyKey, key))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	if code := send("old_key"); code != http.StatusOK {
+		t.Fatalf("expected status %v for the old key; got %v", http.StatusOK, code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx, 10*time.Millisecond)
+
+	// Rotate the key, raising the rate limit of the token.
+	writeTokenFile(t, path, fmt.Sprintf(`{"tokens": [{"name": "new", "hash": %q, "numOfRequestPerSec": 5}]}`, hashOf(t, "new_key")))
+	modTime := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("could not touch token file: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for send("new_key") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatalf("token file was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := send("old_key"); code != http.StatusUnauthorized {
+		t.Errorf("expected status %v for the revoked key; got %v", http.StatusUnauthorized, code)
+	}
+
+	// An invalid file keeps the current tokens.
+	writeTokenFile(t, path, `{"tokens": [`)
+	modTime = modTime.Add(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("could not touch token file: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if code := send("new_key"); code != http.StatusOK {
+		t.Errorf("expected status %v after an invalid reload; got %v", http.StatusOK, code)
+	}
+}
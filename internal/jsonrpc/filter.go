@@ -0,0 +1,225 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-http-utils/headers"
+)
+
+type rejectKey struct{}
+
+const errParseError = "parse error"
+
+// RejectFunc returns an error for the requests that must not be served, or
+// nil.
+type RejectFunc func(Request) *Error
+
+// RejectFromContext returns the rejections of all the filters the request
+// went through. Handlers receiving calls outside of the request body, e.g.
+// over websocket, must apply it to them.
+func RejectFromContext(ctx context.Context) RejectFunc {
+	if reject, ok := ctx.Value(rejectKey{}).(RejectFunc); ok {
+		return reject
+	}
+
+	return func(Request) *Error { return nil }
+}
+
+// Filter returns a handler answering the requests rejected by reject with the
+// returned error and passing the others to next. For batches, next only gets
+// the accepted requests and the responses are merged back in the order of the
+// original batch. Gzip encoded bodies are decoded first, so that their calls
+// are filtered too. Bodies that cannot be parsed are answered with a parse
+// error, while requests without body, e.g. websocket upgrades, are passed to
+// next as is.
+func Filter(reject RejectFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		previous := RejectFromContext(r.Context())
+		r = r.WithContext(context.WithValue(r.Context(), rejectKey{}, RejectFunc(func(request Request) *Error {
+			if rpcErr := previous(request); rpcErr != nil {
+				return rpcErr
+			}
+
+			return reject(request)
+		})))
+
+		body, err := ReadBody(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, nil, CodeParseError, errParseError)
+
+			return
+		}
+
+		if len(body) == 0 {
+			serveBody(next, w, r, body)
+
+			return
+		}
+
+		requests, isBatch, err := ParseRequests(body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, nil, CodeParseError, errParseError)
+
+			return
+		}
+
+		accepted := make([]Request, 0, len(requests))
+		rejected := make(map[int]*Error)
+		for i, request := range requests {
+			if rpcErr := reject(request); rpcErr != nil {
+				rejected[i] = rpcErr
+			} else {
+				accepted = append(accepted, request)
+			}
+		}
+
+		switch {
+		case len(rejected) == 0:
+			serveBody(next, w, r, body)
+		case !isBatch:
+			writeResponses(w, requests, rejected, nil, false)
+		case len(accepted) == 0:
+			writeResponses(w, requests, rejected, nil, true)
+		default:
+			serveMerged(next, w, r, requests, accepted, rejected)
+		}
+	})
+}
+
+// serveMerged serves the accepted requests of a batch with next and merges
+// their responses with the errors of the rejected ones.
+func serveMerged(next http.Handler, w http.ResponseWriter, r *http.Request, requests, accepted []Request, rejected map[int]*Error) {
+	body, err := json.Marshal(accepted)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	recorder := newBufferedResponseWriter()
+	serveBody(next, recorder, r, body)
+
+	responses, ok := recorder.responses()
+	if !ok {
+		// Not a JSON-RPC batch response, e.g. a plain error from the
+		// gateway. It is returned as is.
+		recorder.writeTo(w)
+
+		return
+	}
+
+	byID := make(map[string]Response, len(responses))
+	for _, response := range responses {
+		byID[string(response.ID)] = response
+	}
+
+	writeResponses(w, requests, rejected, byID, true)
+}
+
+// writeResponses writes the responses in the order of the requests: errors for
+// the rejected ones and the given responses for the others. Notifications get
+// no response.
+func writeResponses(w http.ResponseWriter, requests []Request, rejected map[int]*Error, byID map[string]Response, isBatch bool) {
+	responses := make([]Response, 0, len(requests))
+	for i, request := range requests {
+		if request.IsNotification() {
+			continue
+		}
+
+		if rpcErr, ok := rejected[i]; ok {
+			responses = append(responses, Response{JSONRPC: Version, ID: request.ID, Error: rpcErr})
+		} else if response, ok := byID[string(request.ID)]; ok {
+			responses = append(responses, response)
+		}
+	}
+
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	w.Header().Set(headers.ContentType, "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if isBatch {
+		json.NewEncoder(w).Encode(responses) // nolint:errcheck
+	} else {
+		json.NewEncoder(w).Encode(responses[0]) // nolint:errcheck
+	}
+}
+
+func serveBody(next http.Handler, w http.ResponseWriter, r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	next.ServeHTTP(w, r)
+}
+
+// bufferedResponseWriter keeps the response of the next handler so that it
+// can be merged.
+type bufferedResponseWriter struct {
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		header:     http.Header{},
+		statusCode: http.StatusOK,
+	}
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponseWriter) WriteHeader(statusCode int) {
+	b.statusCode = statusCode
+}
+
+func (b *bufferedResponseWriter) responses() ([]Response, bool) {
+	if b.statusCode != http.StatusOK {
+		return nil, false
+	}
+
+	body := b.body.Bytes()
+	if strings.Contains(b.header.Get(headers.ContentEncoding), "gzip") {
+		g, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, false
+		}
+		defer g.Close()
+
+		if body, err = io.ReadAll(g); err != nil {
+			return nil, false
+		}
+	}
+
+	responses, isBatch, err := ParseResponses(body)
+	if err != nil || !isBatch {
+		return nil, false
+	}
+
+	return responses, true
+}
+
+func (b *bufferedResponseWriter) writeTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+
+	w.WriteHeader(b.statusCode)
+	w.Write(b.body.Bytes()) // nolint:errcheck
+}
@@ -0,0 +1,144 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/assert"
+)
+
+func rejectDebug(request Request) *Error {
+	if MatchMethod("debug_*", request.Method) {
+		return &Error{Code: CodeMethodNotFound, Message: "blocked"}
+	}
+
+	return nil
+}
+
+// echoHandler answers every request with its method as result.
+func echoHandler(t *testing.T, calls *[]string) http.Handler {
+	t.Helper()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		*calls = append(*calls, string(body))
+
+		requests, isBatch, err := ParseRequests(body)
+		if err != nil {
+			w.Write(body) // nolint:errcheck
+
+			return
+		}
+
+		responses := make([]Response, 0, len(requests))
+		for _, request := range requests {
+			result, _ := json.Marshal(request.Method)
+			responses = append(responses, Response{JSONRPC: Version, ID: request.ID, Result: result})
+		}
+
+		if isBatch {
+			json.NewEncoder(w).Encode(responses) // nolint:errcheck
+		} else {
+			json.NewEncoder(w).Encode(responses[0]) // nolint:errcheck
+		}
+	})
+}
+
+func serveFiltered(t *testing.T, body string) (*httptest.ResponseRecorder, []string) {
+	t.Helper()
+
+	var calls []string
+	rr := httptest.NewRecorder()
+	Filter(rejectDebug, echoHandler(t, &calls)).
+		ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	return rr, calls
+}
+
+func TestFilterSingleRequest(t *testing.T) {
+	t.Parallel()
+
+	rr, calls := serveFiltered(t, `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+	assert.Len(t, calls, 1)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"eth_chainId"}`, rr.Body.String())
+
+	rr, calls = serveFiltered(t, `{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction"}`)
+	assert.Empty(t, calls)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"blocked"}}`, rr.Body.String())
+
+	rr, calls = serveFiltered(t, `not json`)
+	assert.Empty(t, calls)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`, rr.Body.String())
+}
+
+func TestFilterGzipRequest(t *testing.T) {
+	t.Parallel()
+
+	gzipped := func(body string) *bytes.Buffer {
+		var buf bytes.Buffer
+		g := gzip.NewWriter(&buf)
+		g.Write([]byte(body)) // nolint:errcheck
+		assert.NoError(t, g.Close())
+
+		return &buf
+	}
+
+	serve := func(body io.Reader) (*httptest.ResponseRecorder, []string) {
+		var calls []string
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set(headers.ContentEncoding, "gzip")
+		rr := httptest.NewRecorder()
+		Filter(rejectDebug, echoHandler(t, &calls)).ServeHTTP(rr, req)
+
+		return rr, calls
+	}
+
+	rr, calls := serve(gzipped(`{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction"}`))
+	assert.Empty(t, calls)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"blocked"}}`, rr.Body.String())
+
+	// Accepted calls are passed on decoded.
+	rr, calls = serve(gzipped(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+	assert.Equal(t, []string{`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`}, calls)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"eth_chainId"}`, rr.Body.String())
+
+	rr, calls = serve(strings.NewReader("not gzip"))
+	assert.Empty(t, calls)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestFilterBatch(t *testing.T) {
+	t.Parallel()
+
+	rr, calls := serveFiltered(t, `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},
+		{"jsonrpc":"2.0","id":2,"method":"debug_traceTransaction"},
+		{"jsonrpc":"2.0","method":"debug_traceCall"},
+		{"jsonrpc":"2.0","id":3,"method":"eth_blockNumber"}
+	]`)
+	assert.Len(t, calls, 1)
+	assert.NotContains(t, calls[0], "debug_")
+	assert.JSONEq(t, `[
+		{"jsonrpc":"2.0","id":1,"result":"eth_chainId"},
+		{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"blocked"}},
+		{"jsonrpc":"2.0","id":3,"result":"eth_blockNumber"}
+	]`, rr.Body.String())
+
+	rr, calls = serveFiltered(t, `[{"jsonrpc":"2.0","id":2,"method":"debug_traceTransaction"}]`)
+	assert.Empty(t, calls)
+	assert.JSONEq(t, `[{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"blocked"}}]`, rr.Body.String())
+
+	rr, calls = serveFiltered(t, `[{"jsonrpc":"2.0","method":"debug_traceCall"}]`)
+	assert.Empty(t, calls)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
@@ -0,0 +1,103 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-http-utils/headers"
+)
+
+// WriteError answers every request of the body with the error, echoing their
+// IDs: a single response for a single request and one response per request
+// for a batch. Notifications get no response, unless the body holds nothing
+// else, in which case a single response with a null ID is written. The HTTP
+// status code is kept so that it can be handled by clients and proxies not
+// aware of JSON-RPC.
+func WriteError(w http.ResponseWriter, statusCode int, body []byte, code int, message string) {
+	var response any = NewErrorResponse(nil, code, message)
+
+	if requests, isBatch, err := ParseRequests(body); err == nil {
+		responses := make([]Response, 0, len(requests))
+		for _, request := range requests {
+			if !request.IsNotification() {
+				responses = append(responses, NewErrorResponse(request.ID, code, message))
+			}
+		}
+
+		switch {
+		case isBatch && len(responses) > 0:
+			response = responses
+		case !isBatch && len(responses) == 1:
+			response = responses[0]
+		}
+	}
+
+	w.Header().Set(headers.ContentType, "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response) // nolint:errcheck
+}
+
+// WriteRequestError is WriteError for middlewares that have not read the body
+// of the request yet.
+func WriteRequestError(w http.ResponseWriter, r *http.Request, statusCode int, code int, message string) {
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	WriteError(w, statusCode, body, code, message)
+}
+
+type gzipKey struct{}
+
+// ReadBody reads the body of the request, decompressing it if it was sent
+// gzip encoded. The request is left with the decoded body and without
+// Content-Encoding, so that the handlers it is passed to see the same calls.
+// WasGzipEncoded still reports how the body was sent.
+func ReadBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(r.Header.Get(headers.ContentEncoding), "gzip") {
+		g, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer g.Close()
+
+		if body, err = io.ReadAll(g); err != nil {
+			return nil, err
+		}
+		r.Header.Del(headers.ContentEncoding)
+		*r = *r.WithContext(context.WithValue(r.Context(), gzipKey{}, true))
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	return body, nil
+}
+
+// WasGzipEncoded reports whether the client sent the body of the request gzip
+// encoded, including when it was since decoded by ReadBody.
+func WasGzipEncoded(r *http.Request) bool {
+	if strings.Contains(r.Header.Get(headers.ContentEncoding), "gzip") {
+		return true
+	}
+
+	encoded, _ := r.Context().Value(gzipKey{}).(bool)
+
+	return encoded
+}
@@ -0,0 +1,62 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "single request",
+			body:     `{"jsonrpc":"2.0","id":"a","method":"eth_chainId"}`,
+			expected: `{"jsonrpc":"2.0","id":"a","error":{"code":-32050,"message":"down"}}`,
+		},
+		{
+			name: "batch",
+			body: `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`,
+			expected: `[{"jsonrpc":"2.0","id":1,"error":{"code":-32050,"message":"down"}},` +
+				`{"jsonrpc":"2.0","id":2,"error":{"code":-32050,"message":"down"}}]`,
+		},
+		{
+			name:     "notification",
+			body:     `{"jsonrpc":"2.0","method":"eth_chainId"}`,
+			expected: `{"jsonrpc":"2.0","id":null,"error":{"code":-32050,"message":"down"}}`,
+		},
+		{
+			name:     "invalid body",
+			body:     `{`,
+			expected: `{"jsonrpc":"2.0","id":null,"error":{"code":-32050,"message":"down"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		WriteError(rr, http.StatusServiceUnavailable, []byte(tt.body), CodeNoUpstream, "down")
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code, tt.name)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"), tt.name)
+		assert.JSONEq(t, tt.expected, rr.Body.String(), tt.name)
+	}
+}
+
+func TestWriteRequestErrorKeepsBody(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":3,"method":"eth_chainId"}`))
+	rr := httptest.NewRecorder()
+	WriteRequestError(rr, req, http.StatusUnauthorized, CodeUnauthorized, "unauthorized")
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":3,"error":{"code":-32040,"message":"unauthorized"}}`, rr.Body.String())
+}
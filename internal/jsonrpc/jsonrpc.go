@@ -0,0 +1,129 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+const Version = "2.0"
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Error codes of the errors generated by the gateway itself, within the range
+// reserved for implementation-defined server errors.
+const (
+	CodeRateLimited     = -32005
+	CodeUnauthorized    = -32040
+	CodeForbidden       = -32041
+	CodeQuotaExceeded   = -32042
+	CodeNoUpstream      = -32050
+	CodeUpstreamTimeout = -32051
+)
+
+// nullID is used for errors that cannot be attributed to a request.
+var nullID = json.RawMessage("null") // nolint:gochecknoglobals
+
+// Request is a JSON-RPC 2.0 request object.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Error is a JSON-RPC 2.0 error object.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Response is a JSON-RPC 2.0 response object.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// IsNotification reports whether the request expects no response.
+func (r *Request) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+// NewErrorResponse builds an error response for the request with the given ID.
+// A missing ID is encoded as null.
+func NewErrorResponse(id json.RawMessage, code int, message string) Response {
+	if len(id) == 0 {
+		id = nullID
+	}
+
+	return Response{
+		JSONRPC: Version,
+		ID:      id,
+		Error: &Error{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// MatchMethod reports whether the method matches the pattern. A pattern is
+// either a method name, a prefix followed by a wildcard like debug_*, or a
+// lone wildcard matching every method.
+func MatchMethod(pattern, method string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(method, prefix)
+	}
+
+	return pattern == method
+}
+
+// IsBatch reports whether the body holds a JSON array, i.e. a batch.
+func IsBatch(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// ParseRequests decodes either a single request or a batch of requests. The
+// returned flag reports whether the body was a batch.
+func ParseRequests(body []byte) ([]Request, bool, error) {
+	return parse[Request](body)
+}
+
+// ParseResponses decodes either a single response or a batch of responses.
+// The returned flag reports whether the body was a batch.
+func ParseResponses(body []byte) ([]Response, bool, error) {
+	return parse[Response](body)
+}
+
+func parse[T any](body []byte) ([]T, bool, error) {
+	if IsBatch(body) {
+		var batch []T
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, true, err
+		}
+
+		return batch, true, nil
+	}
+
+	var single T
+	if err := json.Unmarshal(body, &single); err != nil {
+		return nil, false, err
+	}
+
+	return []T{single}, false, nil
+}
@@ -0,0 +1,51 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequests(t *testing.T) {
+	t.Parallel()
+
+	requests, isBatch, err := ParseRequests([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+	assert.NoError(t, err)
+	assert.False(t, isBatch)
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "eth_chainId", requests[0].Method)
+
+	requests, isBatch, err = ParseRequests([]byte(` [{"jsonrpc":"2.0","id":"a","method":"eth_chainId"},
+		{"jsonrpc":"2.0","method":"eth_subscription"}]`))
+	assert.NoError(t, err)
+	assert.True(t, isBatch)
+	assert.Len(t, requests, 2)
+	assert.Equal(t, `"a"`, string(requests[0].ID))
+	assert.True(t, requests[1].IsNotification())
+
+	_, _, err = ParseRequests([]byte(`{`))
+	assert.Error(t, err)
+}
+
+func TestNewErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	body, err := json.Marshal(NewErrorResponse(nil, CodeInvalidRequest, "invalid"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid"}}`, string(body))
+
+	body, err = json.Marshal(NewErrorResponse(json.RawMessage(`7`), CodeMethodNotFound, "not found"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":7,"error":{"code":-32601,"message":"not found"}}`, string(body))
+}
+
+func TestMatchMethod(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, MatchMethod("eth_getProof", "eth_getProof"))
+	assert.False(t, MatchMethod("eth_getProof", "eth_getProofs"))
+	assert.True(t, MatchMethod("debug_*", "debug_traceTransaction"))
+	assert.False(t, MatchMethod("debug_*", "eth_call"))
+	assert.True(t, MatchMethod("*", "eth_call"))
+}
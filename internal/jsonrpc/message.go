@@ -0,0 +1,71 @@
+package jsonrpc
+
+import "context"
+
+type chargeKey struct{}
+
+// ChargeFunc authorizes and charges the calls of a message received outside
+// of the request body, e.g. over a websocket. It returns the error the calls
+// must be answered with instead of being served, or nil.
+type ChargeFunc func(message []byte) *Error
+
+// WithCharge returns a context in which the messages received outside of the
+// request body are charged by charge, after the charges already in ctx.
+// Unlike Filter, the body of the request itself is left to the caller.
+func WithCharge(ctx context.Context, charge ChargeFunc) context.Context {
+	previous := ChargeFromContext(ctx)
+
+	return context.WithValue(ctx, chargeKey{}, ChargeFunc(func(message []byte) *Error {
+		if rpcErr := previous(message); rpcErr != nil {
+			return rpcErr
+		}
+
+		return charge(message)
+	}))
+}
+
+// ChargeFromContext returns the charges of all the middlewares the request
+// went through. Handlers receiving calls outside of the request body, e.g.
+// over websocket, must apply it to every message.
+func ChargeFromContext(ctx context.Context) ChargeFunc {
+	if charge, ok := ctx.Value(chargeKey{}).(ChargeFunc); ok {
+		return charge
+	}
+
+	return func([]byte) *Error { return nil }
+}
+
+type recordKey struct{}
+
+// RecordFunc records the usage of a message received outside of the request
+// body, e.g. over a websocket. It is called when the message is received and
+// returns the function to call with the response once it is sent, or with
+// nil if the message gets none.
+type RecordFunc func(message []byte) func(response []byte)
+
+// WithRecord returns a context in which the messages received outside of the
+// request body are recorded by record, along with the recorders already in
+// ctx.
+func WithRecord(ctx context.Context, record RecordFunc) context.Context {
+	previous := RecordFromContext(ctx)
+
+	return context.WithValue(ctx, recordKey{}, RecordFunc(func(message []byte) func([]byte) {
+		donePrevious, done := previous(message), record(message)
+
+		return func(response []byte) {
+			donePrevious(response)
+			done(response)
+		}
+	}))
+}
+
+// RecordFromContext returns the recorders of all the middlewares the request
+// went through. Handlers receiving calls outside of the request body, e.g.
+// over websocket, must apply it to every message.
+func RecordFromContext(ctx context.Context) RecordFunc {
+	if record, ok := ctx.Value(recordKey{}).(RecordFunc); ok {
+		return record
+	}
+
+	return func([]byte) func([]byte) { return func([]byte) {} }
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Register registers the collector with the default registerer and returns
+// it. If an equal collector is already registered, e.g. by the previous
+// instance of a reloaded gateway, the existing one is returned instead so
+// that the metrics keep their values.
+func Register[T prometheus.Collector](collector T) T {
+	err := prometheus.DefaultRegisterer.Register(collector)
+	if err == nil {
+		return collector
+	}
+
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if errors.As(err, &alreadyRegistered) {
+		if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+
+	panic(err)
+}
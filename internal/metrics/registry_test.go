@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterReturnsExistingCollector(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	opts := prometheus.CounterOpts{Name: "test_total", Help: "Test counter"}
+
+	first := Register(prometheus.NewCounterVec(opts, []string{"label"}))
+	first.WithLabelValues("a").Inc()
+
+	second := Register(prometheus.NewCounterVec(opts, []string{"label"}))
+	assert.Same(t, first, second)
+
+	assert.Panics(t, func() {
+		Register(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_total", Help: "Other help"}))
+	})
+}
@@ -22,13 +22,18 @@ func Gunzip(next http.Handler) http.Handler {
 
 		body := &bytes.Buffer{}
 
+		// A body that cannot be decoded is an error of the client.
 		g, err := gzip.NewReader(r.Body)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
 		}
 
 		if _, err := io.Copy(body, g); err != nil { // nolint:gosec
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
 		}
 
 		r.Header.Del(headers.ContentEncoding)
@@ -40,3 +45,39 @@ func Gunzip(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(fn)
 }
+
+// Gzip compresses the body of requests that are not encoded yet, for
+// upstreams accepting gzip encoded requests.
+func Gzip(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(headers.ContentEncoding) != "" || r.Body == nil {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		body := &bytes.Buffer{}
+		g := gzip.NewWriter(body)
+
+		if _, err := io.Copy(g, r.Body); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+		if err := g.Close(); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+			return
+		}
+
+		// The headers may be shared with other attempts of the request.
+		r = r.Clone(r.Context())
+		r.Header.Set(headers.ContentEncoding, "gzip")
+		r.Body = io.NopCloser(body)
+		r.ContentLength = int64(body.Len())
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -68,4 +68,44 @@ func TestGunzip(t *testing.T) {
 			ServeHTTP(httptest.NewRecorder(),
 				httptest.NewRequest(http.MethodPost, "http://localhost", bytes.NewBufferString(ethChainID)))
 	})
+
+	t.Run("invalid compressed HTTP request", func(t *testing.T) {
+		t.Parallel()
+
+		tests := http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+			t.Error("the request should not be passed on")
+		})
+
+		request := httptest.NewRequest(http.MethodPost, "http://localhost", bytes.NewBufferString(ethChainID))
+		request.Header.Set(headers.ContentEncoding, "gzip")
+		rr := httptest.NewRecorder()
+
+		Gunzip(tests).ServeHTTP(rr, request)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestGzip(t *testing.T) {
+	t.Parallel()
+
+	ethChainID := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`
+
+	var received string
+	next := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip", r.Header.Get(headers.ContentEncoding))
+
+		g, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+
+		body, err := io.ReadAll(g)
+		assert.NoError(t, err)
+		received = string(body)
+	})
+
+	request := httptest.NewRequest(http.MethodPost, "http://localhost", bytes.NewBufferString(ethChainID))
+	Gzip(next).ServeHTTP(httptest.NewRecorder(), request)
+
+	assert.Equal(t, ethChainID, received)
+	// The original request is left untouched.
+	assert.Empty(t, request.Header.Get(headers.ContentEncoding))
 }
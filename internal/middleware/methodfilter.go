@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+// ruleNotAllowed labels calls rejected because no allow pattern matched them.
+const ruleNotAllowed = "not_allowed"
+
+// MethodFilterConfig lists method patterns, either names or prefixes ending
+// with a wildcard like debug_*. Denied methods are always rejected; when the
+// allowlist is not empty, methods missing from it are rejected as well.
+type MethodFilterConfig struct {
+	Allow []string `json:"allow" yaml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny"`
+}
+
+func (c MethodFilterConfig) IsEmpty() bool {
+	return len(c.Allow) == 0 && len(c.Deny) == 0
+}
+
+// Rule returns the rule rejecting the method, either the matching deny
+// pattern or not_allowed, or an empty string if the method is allowed.
+func (c MethodFilterConfig) Rule(method string) string {
+	for _, pattern := range c.Deny {
+		if jsonrpc.MatchMethod(pattern, method) {
+			return pattern
+		}
+	}
+
+	if len(c.Allow) == 0 {
+		return ""
+	}
+
+	for _, pattern := range c.Allow {
+		if jsonrpc.MatchMethod(pattern, method) {
+			return ""
+		}
+	}
+
+	return ruleNotAllowed
+}
+
+// MethodFilter rejects the JSON-RPC calls to methods that are not allowed
+// with a method not found error. Rejected calls are counted by rule; the
+// counter must have a single rule label.
+func MethodFilter(config MethodFilterConfig, rejected *prometheus.CounterVec) func(http.Handler) http.Handler {
+	reject := func(request jsonrpc.Request) *jsonrpc.Error {
+		rule := config.Rule(request.Method)
+		if rule == "" {
+			return nil
+		}
+
+		rejected.WithLabelValues(rule).Inc()
+
+		return &jsonrpc.Error{
+			Code:    jsonrpc.CodeMethodNotFound,
+			Message: fmt.Sprintf("the method %s does not exist/is not available", request.Method),
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return jsonrpc.Filter(reject, next)
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMethodFilterRule(t *testing.T) {
+	t.Parallel()
+
+	config := MethodFilterConfig{
+		Allow: []string{"eth_*", "net_version", "debug_traceTransaction"},
+		Deny:  []string{"debug_*", "eth_sign"},
+	}
+
+	assert.Equal(t, "", config.Rule("eth_call"))
+	assert.Equal(t, "", config.Rule("net_version"))
+	assert.Equal(t, "eth_sign", config.Rule("eth_sign"))
+	assert.Equal(t, "debug_*", config.Rule("debug_traceTransaction"))
+	assert.Equal(t, ruleNotAllowed, config.Rule("admin_peers"))
+
+	assert.Equal(t, "", MethodFilterConfig{Deny: []string{"admin_*"}}.Rule("web3_clientVersion"))
+	assert.True(t, MethodFilterConfig{}.IsEmpty())
+}
+
+func TestMethodFilter(t *testing.T) {
+	t.Parallel()
+
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"rule"})
+
+	var forwarded []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = append(forwarded, string(body))
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"}]`))
+	})
+
+	handler := MethodFilter(MethodFilterConfig{Deny: []string{"admin_*", "personal_*"}}, rejected)(next)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(
+		`{"jsonrpc":"2.0","id":1,"method":"personal_unlockAccount","params":[]}`)))
+	assert.JSONEq(t,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"the method personal_unlockAccount does not exist/is not available"}}`,
+		rr.Body.String())
+	assert.Empty(t, forwarded)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(
+		`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"admin_peers"}]`)))
+	assert.JSONEq(t, `[
+		{"jsonrpc":"2.0","id":1,"result":"0x1"},
+		{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"the method admin_peers does not exist/is not available"}}
+	]`, rr.Body.String())
+	assert.Len(t, forwarded, 1)
+	assert.NotContains(t, forwarded[0], "admin_peers")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(rejected.WithLabelValues("personal_*")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(rejected.WithLabelValues("admin_*")))
+}
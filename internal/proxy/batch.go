@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-http-utils/headers"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+// serveBatch handles a JSON-RPC batch. Batches over the configured size are
+// rejected. When splitting is enabled, the requests that fail on a provider
+// are retried on the next one and the responses are reassembled in the order
+// of the original batch.
+func (p *Proxy) serveBatch(w http.ResponseWriter, r *http.Request, body []byte) {
+	requests, _, err := jsonrpc.ParseRequests(body)
+	if err != nil || len(requests) == 0 {
+		// Let the providers answer with the appropriate error.
+		p.serve(w, r, body)
+
+		return
+	}
+
+	if p.batch.MaxSize > 0 && len(requests) > p.batch.MaxSize {
+		// Every call gets the error, so that clients can match it by ID.
+		jsonrpc.WriteError(w, http.StatusOK, body, jsonrpc.CodeInvalidRequest,
+			fmt.Sprintf("batch of %d requests exceeds the limit of %d", len(requests), p.batch.MaxSize))
+
+		return
+	}
+
+	if !p.batch.Split || hasDuplicateIDs(requests) {
+		p.serve(w, r, body)
+
+		return
+	}
+
+	responses, err := p.forwardBatch(r, requests)
+	if err != nil {
+		p.writeError(w, body, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, responses)
+}
+
+// forwardBatch sends the pending requests to the healthy targets in turn,
+// keeping the successful responses and retrying the rest on the next target.
+// Requests that failed everywhere get the last error seen for them. It
+// returns an error if no provider answered at all.
+func (p *Proxy) forwardBatch(r *http.Request, requests []jsonrpc.Request) ([]jsonrpc.Response, error) {
+	responses := make([]*jsonrpc.Response, len(requests))
+	pending := make([]int, 0, len(requests))
+	for i := range requests {
+		pending = append(pending, i)
+	}
+
+	answered, timedOut := false, false
+	for _, target := range p.healthyTargets(r.Context()) {
+		if len(pending) == 0 {
+			break
+		}
+		if !p.acquire(target, len(pending)) {
+			continue
+		}
+
+		batch := make([]jsonrpc.Request, 0, len(pending))
+		for _, i := range pending {
+			batch = append(batch, requests[i])
+		}
+		// Notifications get no response, so they are sent only once.
+		pending = withoutNotifications(requests, pending)
+
+		body, err := json.Marshal(batch)
+		if err != nil {
+			break
+		}
+
+		start := time.Now()
+		pw := p.forward(target, r, body)
+
+		results, ok := p.batchResults(pw)
+		if !ok {
+			p.observeRequest(target, r, pw.statusCode, start, true)
+			if r.Context().Err() != nil {
+				break
+			}
+			p.metricRequestErrors.WithLabelValues(target.Name(), "rerouted").Inc()
+			timedOut = pw.timedOut
+
+			continue
+		}
+		answered = true
+
+		failed := false
+		stillPending := pending[:0]
+		for _, i := range pending {
+			response, found := results[string(requests[i].ID)]
+			if found {
+				responses[i] = &response
+			}
+			if !found || p.rpcErrors.IsFailure(response.Error) {
+				stillPending = append(stillPending, i)
+				failed = true
+			}
+		}
+		pending = stillPending
+
+		p.observeRequest(target, r, pw.statusCode, start, failed)
+		if failed {
+			p.metricRequestErrors.WithLabelValues(target.Name(), "rpc_error").Inc()
+			p.metricRequestErrors.WithLabelValues(target.Name(), "rerouted").Inc()
+		}
+	}
+
+	if !answered {
+		return nil, failure(timedOut)
+	}
+
+	result := make([]jsonrpc.Response, 0, len(requests))
+	for i, request := range requests {
+		switch {
+		case request.IsNotification():
+			continue
+		case responses[i] != nil:
+			result = append(result, *responses[i])
+		default:
+			result = append(result, jsonrpc.NewErrorResponse(request.ID, jsonrpc.CodeNoUpstream, errNoUpstream.Error()))
+		}
+	}
+
+	return result, nil
+}
+
+// batchResults indexes the responses of a batch by their ID. It returns false
+// if the provider failed or did not answer with a batch.
+func (p *Proxy) batchResults(pw *ReponseWriter) (map[string]jsonrpc.Response, bool) {
+	if p.HasNodeProviderFailed(pw.statusCode) {
+		return nil, false
+	}
+
+	body, err := pw.DecodedBody()
+	if err != nil {
+		return nil, false
+	}
+
+	responses, isBatch, err := jsonrpc.ParseResponses(body)
+	if err != nil || !isBatch {
+		return nil, false
+	}
+
+	results := make(map[string]jsonrpc.Response, len(responses))
+	for _, response := range responses {
+		results[string(response.ID)] = response
+	}
+
+	return results, true
+}
+
+func withoutNotifications(requests []jsonrpc.Request, indexes []int) []int {
+	result := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		if !requests[i].IsNotification() {
+			result = append(result, i)
+		}
+	}
+
+	return result
+}
+
+func hasDuplicateIDs(requests []jsonrpc.Request) bool {
+	seen := make(map[string]bool, len(requests))
+	for _, request := range requests {
+		if request.IsNotification() {
+			continue
+		}
+		if seen[string(request.ID)] {
+			return true
+		}
+		seen[string(request.ID)] = true
+	}
+
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set(headers.ContentType, "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v) // nolint:errcheck
+}
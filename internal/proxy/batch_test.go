@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-http-utils/headers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+// newFakeBatchServer answers every request of a batch with its method as the
+// result, except for the methods in failing which get a rate limit error.
+func newFakeBatchServer(t *testing.T, failing map[string]bool, received *[][]jsonrpc.Request) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests, _, err := jsonrpc.ParseRequests(body)
+		assert.NoError(t, err)
+		*received = append(*received, requests)
+
+		responses := []jsonrpc.Response{}
+		// Answer in reverse order to make sure the gateway reorders them.
+		for i := len(requests) - 1; i >= 0; i-- {
+			request := requests[i]
+			if request.IsNotification() {
+				continue
+			}
+			if failing[request.Method] {
+				responses = append(responses, jsonrpc.NewErrorResponse(request.ID, -32005, "limit exceeded"))
+
+				continue
+			}
+			result, _ := json.Marshal(request.Method)
+			responses = append(responses, jsonrpc.Response{JSONRPC: jsonrpc.Version, ID: request.ID, Result: result})
+		}
+
+		json.NewEncoder(w).Encode(responses)
+	}))
+}
+
+func TestBatchMaxSize(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var received [][]jsonrpc.Request
+	server := newFakeBatchServer(t, nil, &received)
+	defer server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) { c.Proxy.Batch.MaxSize = 2 }, server.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},
+		{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"},
+		{"jsonrpc":"2.0","id":3,"method":"net_version"}
+	]`))
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var responses []jsonrpc.Response
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	assert.Len(t, responses, 3)
+	for i, response := range responses {
+		assert.Equal(t, strconv.Itoa(i+1), string(response.ID))
+		assert.Equal(t, jsonrpc.CodeInvalidRequest, response.Error.Code)
+	}
+	assert.Empty(t, received)
+}
+
+func TestBatchGzipRequest(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var received [][]jsonrpc.Request
+	server := newFakeBatchServer(t, nil, &received)
+	defer server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) { c.Proxy.Batch.MaxSize = 2 }, server.URL)
+
+	send := func(batch string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		g := gzip.NewWriter(&body)
+		g.Write([]byte(batch))
+		assert.NoError(t, g.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/", &body)
+		req.Header.Set(headers.ContentEncoding, "gzip")
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	// Gzip encoded batches are limited like the others.
+	rr := send(`[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},
+		{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"},
+		{"jsonrpc":"2.0","id":3,"method":"net_version"}
+	]`)
+	var responses []jsonrpc.Response
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	assert.Len(t, responses, 3)
+	assert.Equal(t, jsonrpc.CodeInvalidRequest, responses[0].Error.Code)
+	assert.Empty(t, received)
+
+	rr = send(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}]`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, received, 1)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`not gzip`))
+	req.Header.Set(headers.ContentEncoding, "gzip")
+	rr = httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Len(t, received, 1)
+}
+
+func TestBatchSplit(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var receivedA, receivedB [][]jsonrpc.Request
+	serverA := newFakeBatchServer(t, map[string]bool{"eth_getLogs": true}, &receivedA)
+	defer serverA.Close()
+	serverB := newFakeBatchServer(t, nil, &receivedB)
+	defer serverB.Close()
+
+	proxy := createTestProxy(t, func(c *Config) { c.Proxy.Batch.Split = true }, serverA.URL, serverB.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},
+		{"jsonrpc":"2.0","id":"two","method":"eth_getLogs"},
+		{"jsonrpc":"2.0","method":"eth_notify"},
+		{"jsonrpc":"2.0","id":3,"method":"eth_blockNumber"}
+	]`))
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var responses []jsonrpc.Response
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	assert.Len(t, responses, 3)
+	assert.Equal(t, `1`, string(responses[0].ID))
+	assert.Equal(t, `"eth_chainId"`, string(responses[0].Result))
+	assert.Equal(t, `"two"`, string(responses[1].ID))
+	assert.Equal(t, `"eth_getLogs"`, string(responses[1].Result))
+	assert.Equal(t, `3`, string(responses[2].ID))
+	assert.Equal(t, `"eth_blockNumber"`, string(responses[2].Result))
+
+	// Only the failed request is retried on the second provider.
+	assert.Len(t, receivedA, 1)
+	assert.Len(t, receivedA[0], 4)
+	assert.Len(t, receivedB, 1)
+	assert.Len(t, receivedB[0], 1)
+	assert.Equal(t, "eth_getLogs", receivedB[0][0].Method)
+}
+
+func TestBatchSplitAllProvidersFail(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var received [][]jsonrpc.Request
+	server := newFakeBatchServer(t, map[string]bool{"eth_getLogs": true}, &received)
+	defer server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) { c.Proxy.Batch.Split = true }, server.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`[
+		{"jsonrpc":"2.0","id":1,"method":"eth_getLogs"},
+		{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}
+	]`))
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	var responses []jsonrpc.Response
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	assert.Len(t, responses, 2)
+	assert.Equal(t, -32005, responses[0].Error.Code)
+	assert.Equal(t, `"eth_chainId"`, string(responses[1].Result))
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+)
+
+const (
+	defaultBreakerWindow           = time.Second * 30
+	defaultBreakerMinRequests      = 10
+	defaultBreakerErrorRate        = 0.5
+	defaultBreakerCooldown         = time.Second * 30
+	defaultBreakerHalfOpenRequests = 1
+
+	// breakerBuckets is the number of buckets the rolling window is split
+	// into. Outcomes expire one bucket at a time.
+	breakerBuckets = 10
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type breakerBucket struct {
+	start    time.Time
+	requests uint
+	failures uint
+}
+
+// circuitBreaker takes a provider out of rotation when too many of the
+// requests sent to it fail. It opens once the error rate over the rolling
+// window reaches the threshold, rejects requests during the cooldown, then
+// lets a limited number of trial requests through: the breaker closes if they
+// all succeed and opens again on the first failure.
+//
+// A nil circuitBreaker allows every request.
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+	now    func() time.Time
+	// onStateChange is called with the new state, while holding the lock.
+	onStateChange func(breakerState)
+
+	mu       sync.Mutex
+	state    breakerState
+	buckets  [breakerBuckets]breakerBucket
+	openedAt time.Time
+	// trial requests in flight and succeeded while half-open.
+	trials    uint
+	successes uint
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig, onStateChange func(breakerState)) *circuitBreaker {
+	if config.Window <= 0 {
+		config.Window = util.DurationUnmarshalled(defaultBreakerWindow)
+	}
+	if config.MinRequests == 0 {
+		config.MinRequests = defaultBreakerMinRequests
+	}
+	if config.ErrorRate <= 0 || config.ErrorRate > 1 {
+		config.ErrorRate = defaultBreakerErrorRate
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = util.DurationUnmarshalled(defaultBreakerCooldown)
+	}
+	if config.HalfOpenRequests == 0 {
+		config.HalfOpenRequests = defaultBreakerHalfOpenRequests
+	}
+
+	return &circuitBreaker{
+		config:        config,
+		now:           time.Now,
+		onStateChange: onStateChange,
+	}
+}
+
+// Ready reports whether the breaker may let a request through, without
+// reserving a trial request.
+func (b *circuitBreaker) Ready() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return b.cooledDown()
+	case breakerHalfOpen:
+		return b.trials < b.config.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a request may be sent. While half-open, an allowed
+// request is a trial and its outcome must be reported with Record.
+func (b *circuitBreaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if !b.cooledDown() {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+	}
+
+	if b.state == breakerHalfOpen {
+		if b.trials >= b.config.HalfOpenRequests {
+			return false
+		}
+		b.trials++
+	}
+
+	return true
+}
+
+// Record reports the outcome of a request allowed by Allow.
+func (b *circuitBreaker) Record(failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			b.open()
+
+			return
+		}
+
+		b.successes++
+		if b.successes >= b.config.HalfOpenRequests {
+			b.buckets = [breakerBuckets]breakerBucket{}
+			b.setState(breakerClosed)
+		}
+	case breakerClosed:
+		bucket := b.bucket()
+		bucket.requests++
+		if failed {
+			bucket.failures++
+		}
+
+		requests, failures := b.totals()
+		if requests >= b.config.MinRequests &&
+			float64(failures)/float64(requests) >= b.config.ErrorRate {
+			b.open()
+		}
+	case breakerOpen:
+		// Requests allowed before the breaker opened.
+	}
+}
+
+// Cancel releases a request allowed by Allow whose outcome is unknown, e.g.
+// because it was abandoned.
+func (b *circuitBreaker) Cancel() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *circuitBreaker) State() breakerState {
+	if b == nil {
+		return breakerClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = b.now()
+	b.setState(breakerOpen)
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	b.trials = 0
+	b.successes = 0
+
+	if b.state == state {
+		return
+	}
+
+	b.state = state
+	if b.onStateChange != nil {
+		b.onStateChange(state)
+	}
+}
+
+func (b *circuitBreaker) cooledDown() bool {
+	return b.now().Sub(b.openedAt) >= time.Duration(b.config.Cooldown)
+}
+
+// bucket returns the bucket of the current time slot, resetting it if it
+// holds outcomes of a previous window.
+func (b *circuitBreaker) bucket() *breakerBucket {
+	width := time.Duration(b.config.Window) / breakerBuckets
+	start := b.now().Truncate(width)
+
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+
+	return bucket
+}
+
+// totals sums the outcomes within the rolling window.
+func (b *circuitBreaker) totals() (uint, uint) {
+	since := b.now().Add(-time.Duration(b.config.Window))
+
+	var requests, failures uint
+	for _, bucket := range b.buckets {
+		if bucket.start.After(since) {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+
+	return requests, failures
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	var states []breakerState
+	breaker := newCircuitBreaker(CircuitBreakerConfig{
+		Window:           util.DurationUnmarshalled(time.Second * 10),
+		MinRequests:      4,
+		ErrorRate:        0.5,
+		Cooldown:         util.DurationUnmarshalled(time.Second * 5),
+		HalfOpenRequests: 2,
+	}, func(state breakerState) {
+		states = append(states, state)
+	})
+	breaker.now = func() time.Time { return now }
+
+	// Below the minimum number of requests the breaker stays closed.
+	for i := 0; i < 3; i++ {
+		assert.True(t, breaker.Allow())
+		breaker.Record(true)
+	}
+	assert.Equal(t, breakerClosed, breaker.State())
+
+	// Failures outside of the window are forgotten.
+	now = now.Add(time.Second * 11)
+	assert.True(t, breaker.Allow())
+	breaker.Record(true)
+	assert.Equal(t, breakerClosed, breaker.State())
+
+	for i := 0; i < 2; i++ {
+		breaker.Record(false)
+	}
+	breaker.Record(true)
+	assert.Equal(t, breakerOpen, breaker.State())
+	assert.False(t, breaker.Ready())
+	assert.False(t, breaker.Allow())
+
+	// After the cooldown a limited number of trial requests go through.
+	now = now.Add(time.Second * 5)
+	assert.True(t, breaker.Ready())
+	assert.True(t, breaker.Allow())
+	assert.True(t, breaker.Allow())
+	assert.False(t, breaker.Allow())
+	assert.Equal(t, breakerHalfOpen, breaker.State())
+
+	// A failed trial opens the breaker again.
+	breaker.Record(true)
+	assert.Equal(t, breakerOpen, breaker.State())
+
+	now = now.Add(time.Second * 5)
+	assert.True(t, breaker.Allow())
+	breaker.Record(false)
+	assert.Equal(t, breakerHalfOpen, breaker.State())
+	assert.True(t, breaker.Allow())
+	breaker.Record(false)
+	assert.Equal(t, breakerClosed, breaker.State())
+
+	assert.Equal(t, []breakerState{
+		breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed,
+	}, states)
+}
+
+func TestNilCircuitBreakerAllowsEverything(t *testing.T) {
+	var breaker *circuitBreaker
+
+	assert.True(t, breaker.Ready())
+	assert.True(t, breaker.Allow())
+	breaker.Record(true)
+	assert.Equal(t, breakerClosed, breaker.State())
+}
+
+func TestHTTPFailoverProxyCircuitBreaker(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var hitsA atomic.Int64
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsA.Add(1)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}))
+	defer serverA.Close()
+
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer serverB.Close()
+
+	proxy := createTestProxy(t, func(config *Config) {
+		config.Proxy.CircuitBreaker = CircuitBreakerConfig{
+			Enabled:     true,
+			MinRequests: 3,
+			Cooldown:    util.DurationUnmarshalled(time.Hour),
+		}
+	}, serverA.URL, serverB.URL)
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	assert.Equal(t, int64(3), hitsA.Load())
+	assert.Equal(t, "open", proxy.Status()[0].CircuitBreaker)
+	assert.Equal(t, 1.0, testutil.ToFloat64(proxy.metricCircuitBreakerState.WithLabelValues("A", "open")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(proxy.metricCircuitBreakerState.WithLabelValues("A", "closed")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(proxy.metricCircuitBreakerTransitions.WithLabelValues("A", "open")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(proxy.metricCircuitBreakerState.WithLabelValues("B", "closed")))
+}
+
+func TestHTTPFailoverProxyCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var hitsA, hitsB atomic.Int64
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsA.Add(1)
+		io.Copy(io.Discard, r.Body) // nolint:errcheck
+		<-r.Context().Done()
+	}))
+	defer serverA.Close()
+
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsB.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer serverB.Close()
+
+	proxy := createTestProxy(t, func(config *Config) {
+		config.Proxy.UpstreamTimeout = util.DurationUnmarshalled(time.Minute)
+		config.Proxy.CircuitBreaker = CircuitBreakerConfig{
+			Enabled:     true,
+			MinRequests: 3,
+			Cooldown:    util.DurationUnmarshalled(time.Hour),
+		}
+	}, serverA.URL, serverB.URL)
+
+	for i := 0; i < 5; i++ {
+		// Bodies that cannot be decoded are rejected before being sent.
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not gzip"))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		// Clients going away are not failures of the provider either.
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+		proxy.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+		cancel()
+	}
+
+	assert.Equal(t, int64(5), hitsA.Load())
+	assert.Equal(t, int64(0), hitsB.Load())
+	assert.Equal(t, "closed", proxy.Status()[0].CircuitBreaker)
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+const methodSendRawTransaction = "eth_sendRawTransaction"
+
+// Results of a transaction sent to a provider, as exported in metrics.
+const (
+	broadcastAccepted = "accepted"
+	broadcastKnown    = "known"
+	broadcastRejected = "rejected"
+	broadcastFailed   = "failed"
+)
+
+// Errors returned by nodes when the transaction is already in their mempool.
+var knownTransactionErrors = []string{ // nolint:gochecknoglobals
+	"already known",
+	"known transaction",
+	"already imported",
+	"alreadyknown",
+}
+
+type broadcastResult struct {
+	target *NodeProvider
+	pw     *ReponseWriter
+	result string
+}
+
+// broadcastRequest returns the raw transaction request if the body should be
+// broadcast to every healthy target.
+func (p *Proxy) broadcastRequest(body []byte) (jsonrpc.Request, bool) {
+	if !p.broadcastTransactions {
+		return jsonrpc.Request{}, false
+	}
+
+	requests, isBatch, err := jsonrpc.ParseRequests(body)
+	if err != nil || isBatch || requests[0].IsNotification() || requests[0].Method != methodSendRawTransaction {
+		return jsonrpc.Request{}, false
+	}
+
+	return requests[0], true
+}
+
+// dispatchBroadcast sends the transaction to every healthy target
+// concurrently and returns the first successful response. The other targets
+// keep receiving the transaction after the client got its answer, so that it
+// spreads through their mempools. When no target accepts it, the first
+// JSON-RPC error, e.g. insufficient funds, is returned.
+func (p *Proxy) dispatchBroadcast(r *http.Request, body []byte, request jsonrpc.Request) (*ReponseWriter, error) {
+	hash, hashErr := transactionHash(request)
+	ctx := context.WithoutCancel(r.Context())
+
+	targets := p.healthyTargets(r.Context())
+	results := make(chan broadcastResult, len(targets))
+	pending := 0
+
+	for _, target := range targets {
+		if !p.acquire(target, 1) {
+			continue
+		}
+
+		pending++
+		go func(target *NodeProvider) {
+			result := p.broadcastTo(ctx, target, r, body, hash, hashErr == nil)
+			p.metricBroadcastTransactions.WithLabelValues(target.Name(), result.result).Inc()
+			results <- result
+		}(target)
+	}
+
+	var rejected *ReponseWriter
+	timedOut := false
+	for ; pending > 0; pending-- {
+		result := <-results
+
+		switch result.result {
+		case broadcastAccepted:
+			return result.pw, nil
+		case broadcastKnown:
+			return transactionHashResponse(request.ID, hash), nil
+		case broadcastRejected:
+			if rejected == nil {
+				rejected = result.pw
+			}
+		case broadcastFailed:
+			timedOut = timedOut || result.pw.timedOut
+		}
+	}
+
+	if rejected != nil {
+		return rejected, nil
+	}
+
+	return nil, failure(timedOut)
+}
+
+// broadcastTo sends the transaction to a single target and classifies the
+// outcome.
+func (p *Proxy) broadcastTo(ctx context.Context, target *NodeProvider, r *http.Request, body []byte, hash string, hasHash bool) broadcastResult {
+	// The transaction keeps spreading after the client got its answer, so
+	// the outcome is observed against the detached request.
+	r = r.Clone(ctx)
+
+	start := time.Now()
+	pw := p.forward(target, r, body)
+	result := broadcastResult{target: target, pw: pw, result: broadcastFailed}
+
+	response, ok := singleResponse(pw)
+	if p.HasNodeProviderFailed(pw.statusCode) || !ok {
+		p.observeRequest(target, r, pw.statusCode, start, true)
+
+		return result
+	}
+
+	switch {
+	case response.Error == nil:
+		result.result = broadcastAccepted
+	case p.rpcErrors.IsFailure(response.Error):
+		p.metricRequestErrors.WithLabelValues(target.Name(), "rpc_error").Inc()
+	case hasHash && isKnownTransactionError(response.Error):
+		result.result = broadcastKnown
+	case hasHash && isNonceTooLowError(response.Error) && p.hasTransaction(ctx, target, r, hash):
+		// The nonce is used by this very transaction, which was already
+		// included or is pending.
+		result.result = broadcastKnown
+	default:
+		result.result = broadcastRejected
+	}
+
+	p.observeRequest(target, r, pw.statusCode, start, result.result == broadcastFailed)
+
+	return result
+}
+
+// hasTransaction reports whether the target knows the transaction.
+func (p *Proxy) hasTransaction(ctx context.Context, target *NodeProvider, r *http.Request, hash string) bool {
+	if !target.limits.Allow(1) {
+		return false
+	}
+
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"eth_getTransactionByHash","params":[%q]}`, hash)
+
+	pw := p.forward(target, r.Clone(ctx), []byte(body))
+	if p.HasNodeProviderFailed(pw.statusCode) {
+		return false
+	}
+
+	response, ok := singleResponse(pw)
+
+	return ok && response.Error == nil && len(response.Result) > 0 && string(response.Result) != "null"
+}
+
+func singleResponse(pw *ReponseWriter) (jsonrpc.Response, bool) {
+	body, err := pw.DecodedBody()
+	if err != nil {
+		return jsonrpc.Response{}, false
+	}
+
+	responses, isBatch, err := jsonrpc.ParseResponses(body)
+	if err != nil || isBatch {
+		return jsonrpc.Response{}, false
+	}
+
+	return responses[0], true
+}
+
+// transactionHash computes the hash of the raw transaction, which is the
+// Keccak-256 hash of its encoding for both legacy and typed transactions.
+func transactionHash(request jsonrpc.Request) (string, error) {
+	var params []string
+	if err := json.Unmarshal(request.Params, &params); err != nil {
+		return "", err
+	}
+	if len(params) != 1 {
+		return "", fmt.Errorf("expected 1 param, got %d", len(params))
+	}
+
+	raw, err := hexutil.Decode(params[0])
+	if err != nil {
+		return "", err
+	}
+
+	return crypto.Keccak256Hash(raw).Hex(), nil
+}
+
+func transactionHashResponse(id json.RawMessage, hash string) *ReponseWriter {
+	pw := NewResponseWriter()
+	writeJSON(pw, http.StatusOK, jsonrpc.Response{
+		JSONRPC: jsonrpc.Version,
+		ID:      id,
+		Result:  json.RawMessage(fmt.Sprintf("%q", hash)),
+	})
+
+	return pw
+}
+
+func isKnownTransactionError(err *jsonrpc.Error) bool {
+	message := strings.ToLower(err.Message)
+	for _, known := range knownTransactionErrors {
+		if strings.Contains(message, known) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isNonceTooLowError(err *jsonrpc.Error) bool {
+	return strings.Contains(strings.ToLower(err.Message), "nonce too low")
+}
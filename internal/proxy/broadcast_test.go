@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+const rawTransactionRequest = `{"jsonrpc":"2.0","id":7,"method":"eth_sendRawTransaction","params":["0xdeadbeef"]}`
+
+// newFakeTransactionServer answers eth_sendRawTransaction with the given
+// response and eth_getTransactionByHash with a transaction if known is set.
+func newFakeTransactionServer(response string, known bool, hits *atomic.Int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "eth_getTransactionByHash") {
+			if known {
+				w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"hash":"0x01"}}`))
+			} else {
+				w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+			}
+
+			return
+		}
+
+		if hits != nil {
+			hits.Add(1)
+		}
+		w.Write([]byte(response))
+	}))
+}
+
+func sendRawTransaction(proxy *Proxy) jsonrpc.Response {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(rawTransactionRequest))
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	var response jsonrpc.Response
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	return response
+}
+
+func TestBroadcastTransactionToAllTargets(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var hitsA, hitsB atomic.Int64
+	serverA := newFakeTransactionServer(`{"jsonrpc":"2.0","id":7,"result":"0xabc"}`, false, &hitsA)
+	defer serverA.Close()
+	serverB := newFakeTransactionServer(`{"jsonrpc":"2.0","id":7,"result":"0xabc"}`, false, &hitsB)
+	defer serverB.Close()
+
+	proxy := createTestProxy(t, func(config *Config) {
+		config.Proxy.BroadcastTransactions = true
+	}, serverA.URL, serverB.URL)
+
+	response := sendRawTransaction(proxy)
+	assert.Nil(t, response.Error)
+	assert.JSONEq(t, `"0xabc"`, string(response.Result))
+
+	assert.Eventually(t, func() bool {
+		return hitsA.Load() == 1 && hitsB.Load() == 1 &&
+			testutil.ToFloat64(proxy.metricBroadcastTransactions.WithLabelValues("B", broadcastAccepted)) == 1
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestBroadcastTransactionKnownIsSuccess(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	known := newFakeTransactionServer(`{"jsonrpc":"2.0","id":7,"error":{"code":-32000,"message":"already known"}}`, true, nil)
+	defer known.Close()
+
+	mined := newFakeTransactionServer(`{"jsonrpc":"2.0","id":7,"error":{"code":-32000,"message":"nonce too low"}}`, true, nil)
+	defer mined.Close()
+
+	for _, url := range []string{known.URL, mined.URL} {
+		prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+		proxy := createTestProxy(t, func(config *Config) {
+			config.Proxy.BroadcastTransactions = true
+		}, failing.URL, url)
+
+		response := sendRawTransaction(proxy)
+		assert.Nil(t, response.Error)
+		assert.JSONEq(t, `7`, string(response.ID))
+		assert.JSONEq(t, `"`+crypto.Keccak256Hash([]byte{0xde, 0xad, 0xbe, 0xef}).Hex()+`"`, string(response.Result))
+		assert.Equal(t, 1.0, testutil.ToFloat64(proxy.metricBroadcastTransactions.WithLabelValues("B", broadcastKnown)))
+	}
+}
+
+func TestBroadcastTransactionRejected(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	// A different transaction with the same nonce was included.
+	replaced := newFakeTransactionServer(`{"jsonrpc":"2.0","id":7,"error":{"code":-32000,"message":"nonce too low"}}`, false, nil)
+	defer replaced.Close()
+
+	proxy := createTestProxy(t, func(config *Config) {
+		config.Proxy.BroadcastTransactions = true
+	}, replaced.URL)
+
+	response := sendRawTransaction(proxy)
+	assert.NotNil(t, response.Error)
+	assert.Equal(t, "nonce too low", response.Error.Message)
+	assert.Equal(t, 1.0, testutil.ToFloat64(proxy.metricBroadcastTransactions.WithLabelValues("A", broadcastRejected)))
+}
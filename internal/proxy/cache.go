@@ -0,0 +1,306 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+const (
+	defaultCacheSize          = 10000
+	defaultCacheTTL           = time.Hour
+	defaultCacheFinalizedTTL  = 12 * time.Second
+	defaultCacheConfirmations = 64
+)
+
+// Methods whose result never changes for a given chain.
+var cacheStaticMethods = map[string]bool{ // nolint:gochecknoglobals
+	"eth_chainId": true,
+	"net_version": true,
+}
+
+// Methods addressing a block by its hash. Their result is immutable once the
+// block exists.
+var cacheBlockHashMethods = map[string]bool{ // nolint:gochecknoglobals
+	"eth_getBlockByHash":                    true,
+	"eth_getBlockTransactionCountByHash":    true,
+	"eth_getTransactionByBlockHashAndIndex": true,
+	"eth_getUncleByBlockHashAndIndex":       true,
+	"eth_getUncleCountByBlockHash":          true,
+}
+
+// Methods addressing a transaction by its hash. Their result is immutable
+// once the transaction is included in a confirmed block.
+var cacheTransactionHashMethods = map[string]bool{ // nolint:gochecknoglobals
+	"eth_getTransactionByHash":  true,
+	"eth_getTransactionReceipt": true,
+}
+
+// Methods pinned to a block, with the position of the block parameter.
+var cacheBlockParamMethods = map[string]int{ // nolint:gochecknoglobals
+	"eth_getBlockByNumber":                    0,
+	"eth_getBlockReceipts":                    0,
+	"eth_getBlockTransactionCountByNumber":    0,
+	"eth_getTransactionByBlockNumberAndIndex": 0,
+	"eth_getUncleByBlockNumberAndIndex":       0,
+	"eth_getUncleCountByBlockNumber":          0,
+	"eth_getBalance":                          1,
+	"eth_getCode":                             1,
+	"eth_getTransactionCount":                 1,
+	"eth_call":                                1,
+	"eth_getStorageAt":                        2,
+	"eth_getProof":                            2,
+}
+
+// responseCache is an LRU cache of JSON-RPC results with a per entry TTL.
+type responseCache struct {
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+	mu      sync.Mutex
+}
+
+type cacheEntry struct {
+	key     string
+	result  json.RawMessage
+	expires time.Time
+}
+
+func newResponseCache(size int) *responseCache {
+	return &responseCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *responseCache) Get(key string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry) // nolint:forcetypeassert
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+
+	return entry.result, true
+}
+
+func (c *responseCache) Add(key string, result json.RawMessage, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry) // nolint:forcetypeassert
+		entry.result = result
+		entry.expires = expires
+		c.lru.MoveToFront(element)
+
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, result: result, expires: expires})
+
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key) // nolint:forcetypeassert
+	}
+}
+
+func (c *responseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// cachePolicy decides whether and for how long a request can be cached.
+type cachePolicy struct {
+	ttl           time.Duration
+	finalizedTTL  time.Duration
+	confirmations uint64
+}
+
+func newCachePolicy(config CacheConfig) cachePolicy {
+	policy := cachePolicy{
+		ttl:           time.Duration(config.TTL),
+		finalizedTTL:  time.Duration(config.FinalizedTTL),
+		confirmations: config.Confirmations,
+	}
+	if policy.ttl == 0 {
+		policy.ttl = defaultCacheTTL
+	}
+	if policy.finalizedTTL == 0 {
+		policy.finalizedTTL = defaultCacheFinalizedTTL
+	}
+	if policy.confirmations == 0 {
+		policy.confirmations = defaultCacheConfirmations
+	}
+
+	return policy
+}
+
+// TTL returns how long the response of the request may be cached, given the
+// highest known block number. It returns false for requests that cannot be
+// cached.
+func (c cachePolicy) TTL(request jsonrpc.Request, head uint64) (time.Duration, bool) {
+	switch {
+	case cacheStaticMethods[request.Method], cacheBlockHashMethods[request.Method]:
+		return c.ttl, true
+	case cacheTransactionHashMethods[request.Method]:
+		// The result is checked once it is known, see IsConfirmed.
+		return c.ttl, head > 0
+	}
+
+	position, ok := cacheBlockParamMethods[request.Method]
+	if !ok {
+		return 0, false
+	}
+
+	var params []json.RawMessage
+	if err := json.Unmarshal(request.Params, &params); err != nil || len(params) <= position {
+		return 0, false
+	}
+
+	return c.blockTTL(params[position], head)
+}
+
+func (c cachePolicy) blockTTL(param json.RawMessage, head uint64) (time.Duration, bool) {
+	var tag string
+	if err := json.Unmarshal(param, &tag); err != nil {
+		// EIP-1898 block parameter.
+		var block struct {
+			BlockHash   *string         `json:"blockHash"`
+			BlockNumber *hexutil.Uint64 `json:"blockNumber"`
+		}
+		if err := json.Unmarshal(param, &block); err != nil {
+			return 0, false
+		}
+
+		switch {
+		case block.BlockHash != nil:
+			return c.ttl, true
+		case block.BlockNumber != nil:
+			return c.ttl, c.isConfirmed(uint64(*block.BlockNumber), head)
+		default:
+			return 0, false
+		}
+	}
+
+	switch tag {
+	case "earliest":
+		return c.ttl, true
+	case "finalized":
+		return min(c.ttl, c.finalizedTTL), true
+	}
+
+	number, err := hexutil.DecodeUint64(tag)
+	if err != nil {
+		// latest, pending, safe or an invalid value.
+		return 0, false
+	}
+
+	return c.ttl, c.isConfirmed(number, head)
+}
+
+func (c cachePolicy) isConfirmed(blockNumber, head uint64) bool {
+	return head > 0 && blockNumber+c.confirmations <= head
+}
+
+// IsCacheable reports whether the result is worth caching. Null results, e.g.
+// unknown blocks, and transactions that are not confirmed yet may still
+// change.
+func (c cachePolicy) IsCacheable(request jsonrpc.Request, result json.RawMessage, head uint64) bool {
+	if len(result) == 0 || bytes.Equal(result, []byte("null")) {
+		return false
+	}
+
+	if !cacheTransactionHashMethods[request.Method] {
+		return true
+	}
+
+	var tx struct {
+		BlockNumber *hexutil.Uint64 `json:"blockNumber"`
+	}
+	if err := json.Unmarshal(result, &tx); err != nil || tx.BlockNumber == nil {
+		return false
+	}
+
+	return c.isConfirmed(uint64(*tx.BlockNumber), head)
+}
+
+// requestKey identifies a request regardless of its ID and formatting.
+func requestKey(request jsonrpc.Request) string {
+	params := &bytes.Buffer{}
+	if err := json.Compact(params, request.Params); err != nil {
+		params.Write(request.Params)
+	}
+
+	return strings.Join([]string{request.Method, params.String()}, ":")
+}
+
+// serveCached answers the request from the cache, or forwards it and caches
+// the result. It returns false if the request is not cacheable, so it has to
+// be served normally.
+func (p *Proxy) serveCached(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	requests, isBatch, err := jsonrpc.ParseRequests(body)
+	if err != nil || isBatch || requests[0].IsNotification() {
+		return false
+	}
+	request := requests[0]
+
+	head := p.hcm.HighestBlockNumber()
+	ttl, ok := p.cachePolicy.TTL(request, head)
+	if !ok {
+		return false
+	}
+
+	key := requestKey(request)
+	if result, ok := p.cache.Get(key); ok {
+		p.metricCacheRequests.WithLabelValues(request.Method, "hit").Inc()
+		writeJSON(w, http.StatusOK, jsonrpc.Response{JSONRPC: jsonrpc.Version, ID: request.ID, Result: result})
+
+		return true
+	}
+	p.metricCacheRequests.WithLabelValues(request.Method, "miss").Inc()
+
+	pw, err := p.dispatchCoalesced(r, body)
+	if err != nil {
+		p.writeError(w, body, err)
+
+		return true
+	}
+
+	if pw.statusCode == http.StatusOK {
+		if decoded, err := pw.DecodedBody(); err == nil {
+			responses, isBatch, err := jsonrpc.ParseResponses(decoded)
+			if err == nil && !isBatch && responses[0].Error == nil &&
+				p.cachePolicy.IsCacheable(request, responses[0].Result, head) {
+				p.cache.Add(key, responses[0].Result, ttl)
+			}
+		}
+	}
+
+	p.writeResponse(w, pw)
+
+	return true
+}
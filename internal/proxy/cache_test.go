@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+func TestResponseCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	cache := newResponseCache(2)
+	cache.Add("a", json.RawMessage(`1`), time.Hour)
+	cache.Add("b", json.RawMessage(`2`), time.Hour)
+
+	_, ok := cache.Get("a")
+	assert.True(t, ok)
+
+	cache.Add("c", json.RawMessage(`3`), time.Hour)
+	assert.Equal(t, 2, cache.Len())
+
+	_, ok = cache.Get("b")
+	assert.False(t, ok, "least recently used entry should be evicted")
+
+	cache.Add("d", json.RawMessage(`4`), -time.Second)
+	_, ok = cache.Get("d")
+	assert.False(t, ok, "expired entry should not be returned")
+}
+
+func TestCachePolicyTTL(t *testing.T) {
+	t.Parallel()
+
+	policy := newCachePolicy(CacheConfig{})
+	head := uint64(1000)
+
+	tests := []struct {
+		request   string
+		cacheable bool
+	}{
+		{`{"method":"eth_chainId"}`, true},
+		{`{"method":"eth_blockNumber"}`, false},
+		{`{"method":"eth_getBlockByHash","params":["0xabc",false]}`, true},
+		{`{"method":"eth_getBlockByNumber","params":["0x10",false]}`, true},
+		{`{"method":"eth_getBlockByNumber","params":["0x3e0",false]}`, false},
+		{`{"method":"eth_getBlockByNumber","params":["latest",false]}`, false},
+		{`{"method":"eth_getBlockByNumber","params":["finalized",false]}`, true},
+		{`{"method":"eth_getBalance","params":["0x1",{"blockHash":"0xabc"}]}`, true},
+		{`{"method":"eth_getBalance","params":["0x1"]}`, false},
+		{`{"method":"eth_call","params":[{},"0x10"]}`, true},
+		{`{"method":"eth_getTransactionReceipt","params":["0xabc"]}`, true},
+	}
+
+	for _, tt := range tests {
+		var request jsonrpc.Request
+		assert.NoError(t, json.Unmarshal([]byte(tt.request), &request))
+
+		_, cacheable := policy.TTL(request, head)
+		assert.Equal(t, tt.cacheable, cacheable, tt.request)
+	}
+
+	ttl, _ := policy.TTL(jsonrpc.Request{Method: "eth_getBlockByNumber", Params: json.RawMessage(`["finalized"]`)}, head)
+	assert.Equal(t, defaultCacheFinalizedTTL, ttl)
+}
+
+func TestCachePolicyIsCacheable(t *testing.T) {
+	t.Parallel()
+
+	policy := newCachePolicy(CacheConfig{})
+	receipt := jsonrpc.Request{Method: "eth_getTransactionReceipt"}
+
+	assert.False(t, policy.IsCacheable(receipt, json.RawMessage(`null`), 1000))
+	assert.False(t, policy.IsCacheable(receipt, json.RawMessage(`{"blockNumber":"0x3e0"}`), 1000))
+	assert.True(t, policy.IsCacheable(receipt, json.RawMessage(`{"blockNumber":"0x10"}`), 1000))
+	assert.True(t, policy.IsCacheable(jsonrpc.Request{Method: "eth_chainId"}, json.RawMessage(`"0x1"`), 0))
+}
+
+func TestProxyCache(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0xaa36a7"}`))
+	}))
+	defer server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) { c.Proxy.Cache.Enabled = true }, server.URL)
+
+	for _, id := range []string{`1`, `"second"`} {
+		req := httptest.NewRequest(http.MethodPost, "/",
+			bytes.NewBufferString(`{"jsonrpc":"2.0","id":`+id+`,"method":"eth_chainId","params":[]}`))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		var response jsonrpc.Response
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equal(t, `"0xaa36a7"`, string(response.Result))
+		assert.Equal(t, id, string(response.ID))
+	}
+
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-http-utils/headers"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+// Methods that change state or return per-call identifiers. Identical
+// concurrent calls to them must each reach a provider, and a call must not be
+// sent twice.
+var nonIdempotentMethods = map[string]bool{ // nolint:gochecknoglobals
+	"eth_sendRawTransaction":          true,
+	"eth_sendTransaction":             true,
+	"eth_sign":                        true,
+	"eth_signTransaction":             true,
+	"eth_newFilter":                   true,
+	"eth_newBlockFilter":              true,
+	"eth_newPendingTransactionFilter": true,
+	"eth_uninstallFilter":             true,
+	"eth_getFilterChanges":            true,
+	"eth_subscribe":                   true,
+	"eth_unsubscribe":                 true,
+}
+
+var errCoalescedPanic = errors.New("shared request panicked")
+
+type coalescedCall struct {
+	done chan struct{}
+	pw   *ReponseWriter
+	err  error
+}
+
+// coalescer collapses identical concurrent calls into a single one.
+type coalescer struct {
+	calls map[string]*coalescedCall
+	mu    sync.Mutex
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{
+		calls: make(map[string]*coalescedCall),
+	}
+}
+
+// Do runs fn once for all the concurrent callers with the same key. The
+// returned flag reports whether the result was produced for another caller.
+func (c *coalescer) Do(ctx context.Context, key string, fn func() (*ReponseWriter, error)) (*ReponseWriter, bool, error) {
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+			return call.pw, true, call.err
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	// The waiters are released even if fn panics, the panic then goes on
+	// to the caller of this leader.
+	defer func() {
+		if v := recover(); v != nil {
+			call.pw, call.err = nil, fmt.Errorf("%w: %v", errCoalescedPanic, v)
+			c.release(key, call)
+			panic(v)
+		}
+		c.release(key, call)
+	}()
+
+	call.pw, call.err = fn()
+
+	return call.pw, false, call.err
+}
+
+func (c *coalescer) release(key string, call *coalescedCall) {
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// dispatchCoalesced dispatches the body, sharing the upstream call with any
+// identical request already in flight when coalescing is enabled.
+func (p *Proxy) dispatchCoalesced(r *http.Request, body []byte) (*ReponseWriter, error) {
+	if p.coalescer == nil {
+		return p.dispatch(r, body)
+	}
+
+	requests, isBatch, err := jsonrpc.ParseRequests(body)
+	if err != nil || isBatch || requests[0].IsNotification() || nonIdempotentMethods[requests[0].Method] {
+		return p.dispatch(r, body)
+	}
+	request := requests[0]
+
+	pw, shared, err := p.coalescer.Do(r.Context(), requestKey(request), func() (*ReponseWriter, error) {
+		// The call is shared, so it must not be cancelled by this client
+		// going away.
+		return p.dispatch(r.WithContext(context.WithoutCancel(r.Context())), body)
+	})
+	if !shared || err != nil {
+		return pw, err
+	}
+
+	p.metricCoalescedRequests.WithLabelValues(request.Method).Inc()
+
+	return withResponseID(pw, request.ID), nil
+}
+
+// withResponseID returns a copy of the response with the given JSON-RPC ID.
+// The original is left untouched as it is shared between callers.
+func withResponseID(pw *ReponseWriter, id json.RawMessage) *ReponseWriter {
+	body, err := pw.DecodedBody()
+	if err != nil {
+		return pw
+	}
+
+	responses, isBatch, err := jsonrpc.ParseResponses(body)
+	if err != nil || isBatch || bytes.Equal(responses[0].ID, id) {
+		return pw
+	}
+
+	response := responses[0]
+	response.ID = id
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return pw
+	}
+
+	result := NewResponseWriter()
+	for k, v := range pw.header {
+		result.header[k] = append([]string(nil), v...)
+	}
+	result.header.Del(headers.ContentEncoding)
+	result.header.Del(headers.ContentLength)
+	result.statusCode = pw.statusCode
+	result.body.Write(encoded)
+
+	return result
+}
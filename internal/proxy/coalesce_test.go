@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+func TestProxyCoalesceRequests(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// Keep the call in flight long enough for the others to join.
+		time.Sleep(200 * time.Millisecond)
+
+		var request jsonrpc.Request
+		json.NewDecoder(r.Body).Decode(&request)
+		json.NewEncoder(w).Encode(jsonrpc.Response{JSONRPC: jsonrpc.Version, ID: request.ID, Result: json.RawMessage(`"0x10"`)})
+	}))
+	defer server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) { c.Proxy.CoalesceRequests = true }, server.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(
+				`{"jsonrpc":"2.0","id":`+strconv.Itoa(id)+`,"method":"eth_blockNumber","params":[]}`))
+			rr := httptest.NewRecorder()
+			proxy.ServeHTTP(rr, req)
+
+			var response jsonrpc.Response
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, `"0x10"`, string(response.Result))
+			assert.Equal(t, strconv.Itoa(id), string(response.ID))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestProxyCoalesceExcludedMethods(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0xhash"}`))
+	}))
+	defer server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) { c.Proxy.CoalesceRequests = true }, server.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(
+				`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x01"]}`))
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestCoalescerLeaderPanic(t *testing.T) {
+	c := newCoalescer()
+	started := make(chan struct{})
+	waiter := make(chan error, 1)
+
+	go func() {
+		<-started
+		_, shared, err := c.Do(context.Background(), "key", func() (*ReponseWriter, error) {
+			t.Error("the waiter should not run the call")
+
+			return nil, nil
+		})
+		assert.True(t, shared)
+		waiter <- err
+	}()
+
+	assert.Panics(t, func() {
+		c.Do(context.Background(), "key", func() (*ReponseWriter, error) { // nolint:errcheck
+			close(started)
+			// Let the waiter join the call before it panics.
+			time.Sleep(50 * time.Millisecond)
+			panic("boom")
+		})
+	})
+
+	select {
+	case err := <-waiter:
+		assert.ErrorIs(t, err, errCoalescedPanic)
+	case <-time.After(time.Second):
+		t.Fatal("the waiter was not released")
+	}
+	assert.Empty(t, c.calls)
+}
@@ -9,11 +9,151 @@ type HealthCheckConfig struct {
 	Timeout          util.DurationUnmarshalled `json:"timeout"`
 	FailureThreshold uint                      `json:"failureThreshold"`
 	SuccessThreshold uint                      `json:"successThreshold"`
+	// MaxBlockLag is the number of blocks a provider may fall behind the
+	// highest block seen across providers before it is taken out of rotation.
+	// Zero disables the check.
+	MaxBlockLag uint64 `json:"maxBlockLag"`
 }
 
 type ProxyConfig struct { // nolint:revive
 	Path            string                    `json:"path"`
 	UpstreamTimeout util.DurationUnmarshalled `json:"upstreamTimeout"`
+	// Strategy selects how requests are spread across healthy targets. One of
+	// failover (default), round-robin, weighted-random, least-in-flight,
+	// priority-tiers or latency.
+	Strategy string        `json:"strategy"`
+	Latency  LatencyConfig `json:"latency"`
+	// FailoverErrors lists JSON-RPC errors returned with HTTP 200 that are
+	// treated as provider failures.
+	FailoverErrors FailoverErrorsConfig `json:"failoverErrors"`
+	Batch          BatchConfig          `json:"batch"`
+	Cache          CacheConfig          `json:"cache"`
+	// CoalesceRequests collapses identical concurrent requests, same method
+	// and params, into a single upstream call.
+	CoalesceRequests bool                 `json:"coalesceRequests"`
+	CircuitBreaker   CircuitBreakerConfig `json:"circuitBreaker"`
+	Hedge            HedgeConfig          `json:"hedge"`
+	// BroadcastTransactions sends eth_sendRawTransaction to every healthy
+	// target instead of the first one.
+	BroadcastTransactions bool `json:"broadcastTransactions"`
+	// Routes send methods to the targets with given tags. The first route
+	// matching a method is used; methods matching no route are served by
+	// every target.
+	Routes []RouteConfig `json:"routes"`
+	// RateLimitBackoff controls how long targets answering 429 Too Many
+	// Requests are taken out of rotation.
+	RateLimitBackoff RateLimitBackoffConfig `json:"rateLimitBackoff"`
+}
+
+// RateLimitBackoffConfig controls the cooldown of targets answering 429 Too
+// Many Requests. The cooldown lasts as long as the target asks for with
+// Retry-After or a rate limit reset header, and at least Initial, doubled for
+// each consecutive rate limited response.
+type RateLimitBackoffConfig struct {
+	// Disabled keeps rate limited targets in rotation, only rerouting the
+	// rate limited requests.
+	Disabled bool `json:"disabled"`
+	// Initial is the cooldown after the first rate limited response. Defaults
+	// to 1s.
+	Initial util.DurationUnmarshalled `json:"initial"`
+	// Max caps the cooldown, including the one asked for by the target.
+	// Defaults to 5m.
+	Max util.DurationUnmarshalled `json:"max"`
+}
+
+// RouteConfig sends the methods matching one of Methods, either names or
+// prefixes ending with a wildcard like debug_*, to the targets having one of
+// Tags. Methods of a route without tags or whose tags no target has are
+// rejected.
+type RouteConfig struct {
+	Methods []string `json:"methods"`
+	Tags    []string `json:"tags"`
+}
+
+// HedgeConfig controls hedged requests: when a provider is slow to answer a
+// read-only request, the request is also sent to the next provider and the
+// first response is used.
+type HedgeConfig struct {
+	Enabled bool `json:"enabled"`
+	// Delay is how long to wait for a provider before hedging. Defaults to
+	// 200ms.
+	Delay util.DurationUnmarshalled `json:"delay"`
+	// Percentile, between 0 and 1, derives the delay from recent response
+	// times instead, e.g. 0.95 hedges requests slower than 95% of them.
+	Percentile float64 `json:"percentile"`
+	// MaxAttempts is the maximum number of providers a request is sent to
+	// concurrently. Defaults to 2.
+	MaxAttempts int `json:"maxAttempts"`
+	// Methods lists the methods that can be hedged. Defaults to common
+	// read-only methods. Transaction submission is never hedged.
+	Methods []string `json:"methods"`
+}
+
+// CircuitBreakerConfig controls the per-provider circuit breaker fed by the
+// outcome of proxied requests.
+type CircuitBreakerConfig struct {
+	Enabled bool `json:"enabled"`
+	// Window is the rolling window over which the error rate is computed.
+	// Defaults to 30s.
+	Window util.DurationUnmarshalled `json:"window"`
+	// MinRequests is the number of requests within the window below which
+	// the breaker never opens. Defaults to 10.
+	MinRequests uint `json:"minRequests"`
+	// ErrorRate is the ratio of failed requests, between 0 and 1, at which
+	// the breaker opens. Defaults to 0.5.
+	ErrorRate float64 `json:"errorRate"`
+	// Cooldown is how long the breaker stays open before letting trial
+	// requests through. Defaults to 30s.
+	Cooldown util.DurationUnmarshalled `json:"cooldown"`
+	// HalfOpenRequests is the number of successful trial requests needed to
+	// close the breaker. Defaults to 1.
+	HalfOpenRequests uint `json:"halfOpenRequests"`
+}
+
+// CacheConfig controls the in-memory cache of immutable JSON-RPC results:
+// chain ID, blocks and transactions by hash, and calls pinned to a block that
+// is either old enough or finalized.
+type CacheConfig struct {
+	Enabled bool `json:"enabled"`
+	// Size is the maximum number of cached results. Defaults to 10000.
+	Size int `json:"size"`
+	// TTL is how long a result is kept. Defaults to 1h.
+	TTL util.DurationUnmarshalled `json:"ttl"`
+	// FinalizedTTL is how long results for the finalized tag are kept, as
+	// the finalized block moves forward. Defaults to 12s.
+	FinalizedTTL util.DurationUnmarshalled `json:"finalizedTTL"`
+	// Confirmations is how many blocks behind the highest known block an
+	// explicit block number has to be to be cached. Defaults to 64.
+	Confirmations uint64 `json:"confirmations"`
+}
+
+// BatchConfig controls the handling of JSON-RPC batch requests.
+type BatchConfig struct {
+	// MaxSize is the maximum number of requests in a batch. Zero disables the
+	// limit.
+	MaxSize int `json:"maxSize"`
+	// Split enables retrying only the failed requests of a batch on the next
+	// providers instead of the whole batch.
+	Split bool `json:"split"`
+}
+
+// FailoverErrorsConfig matches JSON-RPC errors either by code or by a
+// case-insensitive substring of the message. When both lists are empty, a
+// default set covering rate limits, internal errors and missing state is
+// used. Execution reverts are never treated as failures.
+type FailoverErrorsConfig struct {
+	Codes    []int    `json:"codes"`
+	Messages []string `json:"messages"`
+}
+
+// LatencyConfig tunes the moving average of provider response times used by
+// the latency strategy.
+type LatencyConfig struct {
+	// Alpha is the weight of the newest sample, between 0 and 1. Defaults to 0.3.
+	Alpha float64 `json:"alpha"`
+	// ErrorPenalty is added to the response time of failed requests. Defaults
+	// to 1s.
+	ErrorPenalty util.DurationUnmarshalled `json:"errorPenalty"`
 }
 
 // This struct is temporary. It's about to keep the input interface clean and simple.
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const drainPollInterval = 100 * time.Millisecond
+
+// ProviderStatus is a snapshot of the runtime state of a node provider.
+type ProviderStatus struct {
+	Name           string  `json:"name"`
+	Healthy        bool    `json:"healthy"`
+	Lagging        bool    `json:"lagging"`
+	HealthOverride string  `json:"healthOverride,omitempty"`
+	BlockNumber    uint64  `json:"blockNumber"`
+	LatencyMs      float64 `json:"latencyMs"`
+	InFlight       int64   `json:"inFlight"`
+	Cordoned       bool    `json:"cordoned"`
+	CircuitBreaker string  `json:"circuitBreaker"`
+	// BudgetRemaining is only set for providers with a daily budget.
+	BudgetRemaining *uint64 `json:"budgetRemaining,omitempty"`
+	// CooldownUntil is only set while the provider is out of rotation after
+	// being rate limited.
+	CooldownUntil *time.Time `json:"cooldownUntil,omitempty"`
+}
+
+// Status returns the state of every target, in priority order.
+func (p *Proxy) Status() []ProviderStatus {
+	targets := p.allTargets()
+	statuses := make([]ProviderStatus, 0, len(targets))
+
+	for _, target := range targets {
+		health := p.hcm.Status(target.Name())
+		status := ProviderStatus{
+			Name:           target.Name(),
+			Healthy:        p.hcm.IsHealthy(target.Name()),
+			Lagging:        health.Lagging,
+			HealthOverride: health.Override,
+			BlockNumber:    health.BlockNumber,
+			LatencyMs:      float64(target.Latency()) / float64(time.Millisecond),
+			InFlight:       target.InFlight(),
+			Cordoned:       target.IsCordoned(),
+			CircuitBreaker: target.breaker.State().String(),
+		}
+		if remaining, ok := target.limits.Remaining(); ok {
+			status.BudgetRemaining = &remaining
+		}
+		if until, ok := target.cooldown.Until(); ok {
+			status.CooldownUntil = &until
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// OperatorState is the state of the targets changed by an operator, as
+// opposed to the one following from the traffic and the health checks.
+type OperatorState struct {
+	// Priority is the order set with SetPriority, nil if it was never set.
+	Priority        []string
+	Cordoned        []string
+	HealthOverrides map[string]string
+}
+
+// OperatorState returns the state of the targets changed by an operator.
+func (p *Proxy) OperatorState() OperatorState {
+	p.mu.RLock()
+	state := OperatorState{
+		Priority:        append([]string(nil), p.priority...),
+		HealthOverrides: make(map[string]string),
+	}
+	p.mu.RUnlock()
+
+	for _, target := range p.allTargets() {
+		if target.IsCordoned() {
+			state.Cordoned = append(state.Cordoned, target.Name())
+		}
+		if override := p.hcm.Status(target.Name()).Override; override != "" {
+			state.HealthOverrides[target.Name()] = override
+		}
+	}
+
+	return state
+}
+
+// RestoreOperatorState applies the state changed by an operator on the
+// proxy this one replaces. Targets that no longer exist are skipped.
+func (p *Proxy) RestoreOperatorState(state OperatorState) {
+	if state.Priority != nil {
+		names := make([]string, 0, len(state.Priority))
+		for _, name := range state.Priority {
+			if _, err := p.target(name); err == nil {
+				names = append(names, name)
+			}
+		}
+		p.SetPriority(names) // nolint:errcheck
+	}
+
+	for _, name := range state.Cordoned {
+		p.Cordon(name, true) // nolint:errcheck
+	}
+
+	for name, override := range state.HealthOverrides {
+		p.SetHealthOverride(name, override) // nolint:errcheck
+	}
+}
+
+// Cordon stops or resumes sending new requests to the target. Requests in
+// flight are not affected.
+func (p *Proxy) Cordon(name string, cordoned bool) error {
+	target, err := p.target(name)
+	if err != nil {
+		return err
+	}
+
+	target.cordoned.Store(cordoned)
+
+	return nil
+}
+
+// Drain cordons the target and waits until it has no request in flight.
+func (p *Proxy) Drain(ctx context.Context, name string) error {
+	target, err := p.target(name)
+	if err != nil {
+		return err
+	}
+
+	target.cordoned.Store(true)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for target.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("provider %s still has %d requests in flight: %w", name, target.InFlight(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// SetPriority reorders the targets. The listed targets come first, in the
+// given order, followed by the others in their current order.
+func (p *Proxy) SetPriority(names []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	byName := make(map[string]*NodeProvider, len(p.targets))
+	for _, target := range p.targets {
+		byName[target.Name()] = target
+	}
+
+	ordered := make([]*NodeProvider, 0, len(p.targets))
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		target, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("unknown provider %q", name)
+		}
+		if listed[name] {
+			return fmt.Errorf("provider %q listed twice", name)
+		}
+
+		listed[name] = true
+		ordered = append(ordered, target)
+	}
+
+	for _, target := range p.targets {
+		if !listed[target.Name()] {
+			ordered = append(ordered, target)
+		}
+	}
+
+	p.targets = ordered
+	p.priority = append([]string(nil), names...)
+
+	return nil
+}
+
+// SetHealthOverride forces the health status of the target, see
+// HealthCheckManager.SetOverride.
+func (p *Proxy) SetHealthOverride(name string, override string) error {
+	if _, err := p.target(name); err != nil {
+		return err
+	}
+
+	return p.hcm.SetOverride(name, override)
+}
+
+func (p *Proxy) allTargets() []*NodeProvider {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return append([]*NodeProvider(nil), p.targets...)
+}
+
+func (p *Proxy) target(name string) (*NodeProvider, error) {
+	for _, target := range p.allTargets() {
+		if target.Name() == name {
+			return target, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown provider %q", name)
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateLimitInitialCooldown = time.Second
+	defaultRateLimitMaxCooldown     = time.Minute * 5
+)
+
+// rateLimitCooldown takes a provider out of rotation after it answered with
+// 429 Too Many Requests. A nil cooldown never does.
+type rateLimitCooldown struct {
+	initial     time.Duration
+	max         time.Duration
+	until       time.Time
+	consecutive int
+	mu          sync.Mutex
+	now         func() time.Time
+}
+
+func newRateLimitCooldown(config RateLimitBackoffConfig) *rateLimitCooldown {
+	if config.Disabled {
+		return nil
+	}
+
+	c := &rateLimitCooldown{
+		initial: time.Duration(config.Initial),
+		max:     time.Duration(config.Max),
+		now:     time.Now,
+	}
+	if c.initial <= 0 {
+		c.initial = defaultRateLimitInitialCooldown
+	}
+	if c.max <= 0 {
+		c.max = defaultRateLimitMaxCooldown
+	}
+
+	return c
+}
+
+// Ready reports whether the cooldown is over.
+func (c *rateLimitCooldown) Ready() bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.now().Before(c.until)
+}
+
+// Until returns the end of the current cooldown, and false if there is none.
+func (c *rateLimitCooldown) Until() (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.until, c.now().Before(c.until)
+}
+
+// Trip starts a cooldown after a rate limited response, lasting as long as
+// the provider asked for, if at all, and doubling with every consecutive rate
+// limited response. Responses arriving during the cooldown, e.g. to requests
+// sent before it started, only extend it to what the provider asked for.
+// It returns the end of the cooldown.
+func (c *rateLimitCooldown) Trip(retryAfter time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	cooldown := retryAfter
+	if !now.Before(c.until) {
+		c.consecutive++
+		backoff := time.Duration(float64(c.initial) * math.Pow(2, float64(c.consecutive-1)))
+		cooldown = max(cooldown, backoff)
+	}
+	cooldown = min(cooldown, c.max)
+
+	if until := now.Add(cooldown); until.After(c.until) {
+		c.until = until
+	}
+
+	return c.until
+}
+
+// Reset starts the backoff over after a response that was not rate limited.
+func (c *rateLimitCooldown) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.consecutive = 0
+}
+
+// retryAfter returns how long the provider asked to wait before sending
+// further requests, or zero if it did not, from the standard Retry-After header or one of the
+// rate limit reset headers used by providers. X-RateLimit-Reset is either a
+// number of seconds or a Unix time, in seconds or milliseconds.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+			return secondsDuration(seconds)
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0)
+		}
+	}
+
+	if seconds, err := strconv.ParseFloat(header.Get("RateLimit-Reset"), 64); err == nil && seconds >= 0 {
+		return secondsDuration(seconds)
+	}
+
+	if reset, err := strconv.ParseFloat(header.Get("X-RateLimit-Reset"), 64); err == nil && reset >= 0 {
+		switch {
+		case reset > 1e12:
+			return max(time.UnixMilli(int64(reset)).Sub(now), 0)
+		case reset > 1e9:
+			return max(time.Unix(int64(reset), 0).Sub(now), 0)
+		default:
+			return secondsDuration(reset)
+		}
+	}
+
+	return 0
+}
+
+// secondsDuration converts seconds to a duration, capped far beyond any
+// cooldown so that it cannot overflow.
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(min(seconds, 1e9) * float64(time.Second))
+}
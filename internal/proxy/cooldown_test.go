@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+	}{
+		{name: "none", header: http.Header{}, expected: 0},
+		{name: "seconds", header: http.Header{"Retry-After": {"3"}}, expected: 3 * time.Second},
+		{name: "date", header: http.Header{"Retry-After": {now.Add(time.Minute).UTC().Format(http.TimeFormat)}}, expected: time.Minute},
+		{name: "past date", header: http.Header{"Retry-After": {now.Add(-time.Minute).UTC().Format(http.TimeFormat)}}, expected: 0},
+		{name: "invalid", header: http.Header{"Retry-After": {"soon"}}, expected: 0},
+		{name: "ratelimit reset", header: http.Header{"Ratelimit-Reset": {"7"}}, expected: 7 * time.Second},
+		{name: "x-ratelimit reset seconds", header: http.Header{"X-Ratelimit-Reset": {"1.5"}}, expected: 1500 * time.Millisecond},
+		{name: "x-ratelimit reset unix", header: http.Header{"X-Ratelimit-Reset": {"1700000010"}}, expected: 10 * time.Second},
+		{name: "x-ratelimit reset unix ms", header: http.Header{"X-Ratelimit-Reset": {"1700000000500"}}, expected: 500 * time.Millisecond},
+		{name: "huge", header: http.Header{"Retry-After": {"1e300"}}, expected: 1e9 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, retryAfter(tt.header, now))
+		})
+	}
+}
+
+func TestRateLimitCooldownBackoff(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cooldown := newRateLimitCooldown(RateLimitBackoffConfig{
+		Initial: util.DurationUnmarshalled(time.Second),
+		Max:     util.DurationUnmarshalled(time.Second * 10),
+	})
+	cooldown.now = func() time.Time { return now }
+
+	assert.True(t, cooldown.Ready())
+	assert.Equal(t, now.Add(time.Second), cooldown.Trip(0))
+	assert.False(t, cooldown.Ready())
+
+	// Responses to requests sent before the cooldown do not double it.
+	assert.Equal(t, now.Add(time.Second), cooldown.Trip(0))
+
+	// Consecutive rate limits double the cooldown, up to the maximum.
+	for _, expected := range []time.Duration{2, 4, 8, 10} {
+		now = now.Add(time.Minute)
+		assert.True(t, cooldown.Ready())
+		assert.Equal(t, now.Add(expected*time.Second), cooldown.Trip(0))
+	}
+
+	// The cooldown asked by the provider is honored when it is longer.
+	cooldown.Reset()
+	now = now.Add(time.Minute)
+	assert.Equal(t, now.Add(5*time.Second), cooldown.Trip(5*time.Second))
+	assert.Equal(t, now.Add(10*time.Second), cooldown.Trip(time.Hour))
+
+	until, ok := cooldown.Until()
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(10*time.Second), until)
+}
+
+func TestNilRateLimitCooldown(t *testing.T) {
+	cooldown := newRateLimitCooldown(RateLimitBackoffConfig{Disabled: true})
+
+	assert.Nil(t, cooldown)
+	assert.True(t, cooldown.Ready())
+	_, ok := cooldown.Until()
+	assert.False(t, ok)
+}
+
+func TestHTTPFailoverProxyRateLimitCooldown(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var hitsA atomic.Int64
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsA.Add(1)
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	}))
+	defer serverA.Close()
+
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)) // nolint:errcheck
+	}))
+	defer serverB.Close()
+
+	proxy := createTestProxy(t, nil, serverA.URL, serverB.URL)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	assert.Equal(t, int64(1), hitsA.Load())
+	assert.Equal(t, 1.0, testutil.ToFloat64(proxy.metricProviderRateLimited.WithLabelValues("A")))
+	assert.InDelta(t, float64(start.Add(time.Minute).Unix()),
+		testutil.ToFloat64(proxy.metricProviderCooldownUntil.WithLabelValues("A")), 2)
+
+	status := proxy.Status()
+	assert.NotNil(t, status[0].CooldownUntil)
+	assert.Nil(t, status[1].CooldownUntil)
+}
@@ -23,6 +23,8 @@ type HealthCheckerConfig struct {
 	URL    string
 	Name   string // identifier imported from RPC gateway config
 	Logger *slog.Logger
+	// Header is sent with every probe, e.g. to authenticate with the provider.
+	Header http.Header
 
 	// How often to check health.
 	Interval util.DurationUnmarshalled `json:"interval"`
@@ -35,6 +37,9 @@ type HealthCheckerConfig struct {
 
 	// Minimum consecutive successes required to mark as healthy
 	SuccessThreshold uint `yaml:"successThreshold"`
+
+	// Called whenever the provider changes its health status.
+	OnStatusChange func(healthy bool)
 }
 
 type HealthChecker struct {
@@ -51,11 +56,15 @@ type HealthChecker struct {
 	// is the ethereum RPC node healthy according to the RPCHealthchecker
 	isHealthy bool
 
+	// consecutive probe results used to evaluate the thresholds.
+	consecutiveFailures  uint
+	consecutiveSuccesses uint
+
 	mu sync.RWMutex
 }
 
 func NewHealthChecker(config HealthCheckerConfig, networkName string) (*HealthChecker, error) {
-	client, err := rpc.Dial(config.URL)
+	client, err := rpc.DialOptions(context.Background(), config.URL, rpc.WithHeaders(config.Header))
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +118,7 @@ func (h *HealthChecker) checkBlockNumber(c context.Context) (uint64, error) {
 // RPC provider's side.
 // nolint: unused
 func (h *HealthChecker) checkGasLimit(c context.Context) (uint64, error) {
-	gasLimit, err := performGasLeftCall(c, h.httpClient, h.config.URL)
+	gasLimit, err := performGasLeftCall(c, h.httpClient, h.config.URL, h.config.Header)
 	if err != nil {
 		h.logger.Error("could not fetch gas limit", "error", err)
 
@@ -141,13 +150,52 @@ func (h *HealthChecker) checkAndSetBlockNumberHealth() {
 	// health checking but it provides additional context.
 
 	blockNumber, err := h.checkBlockNumber(c)
-	if err != nil {
-		return
-	}
 
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	if err != nil {
+		h.recordFailure()
+
+		return
+	}
 	h.blockNumber = blockNumber
+	h.recordSuccess()
+}
+
+// recordFailure marks the provider as unhealthy once FailureThreshold
+// consecutive probes have failed. It must be called with h.mu held.
+func (h *HealthChecker) recordFailure() {
+	h.consecutiveSuccesses = 0
+	h.consecutiveFailures++
+
+	if h.isHealthy && h.consecutiveFailures >= max(h.config.FailureThreshold, 1) {
+		h.setHealthy(false)
+	}
+}
+
+// recordSuccess marks the provider as healthy again once SuccessThreshold
+// consecutive probes have succeeded. It must be called with h.mu held.
+func (h *HealthChecker) recordSuccess() {
+	h.consecutiveFailures = 0
+	h.consecutiveSuccesses++
+
+	if !h.isHealthy && h.consecutiveSuccesses >= max(h.config.SuccessThreshold, 1) {
+		h.setHealthy(true)
+	}
+}
+
+func (h *HealthChecker) setHealthy(healthy bool) {
+	h.isHealthy = healthy
+
+	if healthy {
+		h.logger.Warn("provider marked as healthy", "consecutiveSuccesses", h.consecutiveSuccesses)
+	} else {
+		h.logger.Warn("provider marked as unhealthy", "consecutiveFailures", h.consecutiveFailures)
+	}
+
+	if h.config.OnStatusChange != nil {
+		h.config.OnStatusChange(healthy)
+	}
 }
 
 // nolint: unused
@@ -183,6 +231,21 @@ func (h *HealthChecker) Start(c context.Context) {
 	}
 }
 
+// copyFrom replaces the health status and the probe results with the ones of
+// other.
+func (h *HealthChecker) copyFrom(other *HealthChecker) {
+	other.mu.RLock()
+	blockNumber, gasLimit, isHealthy := other.blockNumber, other.gasLimit, other.isHealthy
+	failures, successes := other.consecutiveFailures, other.consecutiveSuccesses
+	other.mu.RUnlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.blockNumber, h.gasLimit, h.isHealthy = blockNumber, gasLimit, isHealthy
+	h.consecutiveFailures, h.consecutiveSuccesses = failures, successes
+}
+
 func (h *HealthChecker) Stop(_ context.Context) error {
 	// TODO: Additional cleanups?
 	return nil
@@ -3,7 +3,10 @@ package proxy
 import (
 	"context"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -43,3 +46,93 @@ func TestBasicHealthchecker(t *testing.T) {
 	healthchecker.isHealthy = true
 	assert.True(t, healthchecker.IsHealthy())
 }
+
+// newFakeBlockNumberServer returns a server answering eth_blockNumber with the
+// given block number, or with HTTP 500 while failing is set.
+func newFakeBlockNumberServer(blockNumber string, failing *atomic.Bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + blockNumber + `"}`))
+	}))
+}
+
+func TestHealthcheckerThresholds(t *testing.T) {
+	var failing atomic.Bool
+	server := newFakeBlockNumberServer("0x10", &failing)
+	defer server.Close()
+
+	var transitions []bool
+	healthchecker, err := NewHealthChecker(HealthCheckerConfig{
+		URL:              server.URL,
+		Name:             "fake",
+		Timeout:          util.DurationUnmarshalled(time.Second),
+		FailureThreshold: 2,
+		SuccessThreshold: 3,
+		Logger:           slog.New(slog.NewTextHandler(os.Stderr, nil)),
+		OnStatusChange: func(healthy bool) {
+			transitions = append(transitions, healthy)
+		},
+	}, "test")
+	assert.NoError(t, err)
+
+	healthchecker.checkAndSetBlockNumberHealth()
+	assert.True(t, healthchecker.IsHealthy())
+	assert.Equal(t, uint64(16), healthchecker.BlockNumber())
+
+	failing.Store(true)
+
+	healthchecker.checkAndSetBlockNumberHealth()
+	assert.True(t, healthchecker.IsHealthy())
+
+	healthchecker.checkAndSetBlockNumberHealth()
+	assert.False(t, healthchecker.IsHealthy())
+
+	failing.Store(false)
+
+	healthchecker.checkAndSetBlockNumberHealth()
+	healthchecker.checkAndSetBlockNumberHealth()
+	assert.False(t, healthchecker.IsHealthy())
+
+	healthchecker.checkAndSetBlockNumberHealth()
+	assert.True(t, healthchecker.IsHealthy())
+
+	assert.Equal(t, []bool{false, true}, transitions)
+}
+
+func TestHealthcheckerSendsHeaders(t *testing.T) {
+	var failing atomic.Bool
+	fake := newFakeBlockNumberServer("0x10", &failing)
+	defer fake.Close()
+
+	// Only authenticated probes reach the node.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	healthchecker, err := NewHealthChecker(HealthCheckerConfig{
+		URL:              server.URL,
+		Name:             "fake",
+		Header:           http.Header{"X-Api-Key": []string{"key"}},
+		Timeout:          util.DurationUnmarshalled(time.Second),
+		FailureThreshold: 1,
+		SuccessThreshold: 1,
+		Logger:           slog.New(slog.NewTextHandler(os.Stderr, nil)),
+	}, "test")
+	assert.NoError(t, err)
+
+	healthchecker.checkAndSetBlockNumberHealth()
+	assert.True(t, healthchecker.IsHealthy())
+	assert.Equal(t, uint64(16), healthchecker.BlockNumber())
+}
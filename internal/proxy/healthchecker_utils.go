@@ -25,7 +25,7 @@ func hexToUint(hexString string) (uint64, error) {
 	return strconv.ParseUint(hexString, 16, 64)
 }
 
-func performGasLeftCall(c context.Context, client *http.Client, url string) (uint64, error) {
+func performGasLeftCall(c context.Context, client *http.Client, url string, header http.Header) (uint64, error) {
 	var gasLeftCallRaw = bytes.NewBufferString(`
 {
     "method": "eth_call",
@@ -54,6 +54,9 @@ func performGasLeftCall(c context.Context, client *http.Client, url string) (uin
 		return 0, fmt.Errorf("performGasLeftCall: NewRequestWithContext error: %w", err)
 	}
 
+	for name, values := range header {
+		r.Header[name] = values
+	}
 	r.Header.Add(headers.ContentType, "application/json")
 	r.Header.Set(headers.UserAgent, userAgent)
 
@@ -28,7 +28,7 @@ func TestPerformGasLeftCallErrors(t *testing.T) {
 		)
 		defer server.Close()
 
-		gas, err := performGasLeftCall(context.TODO(), &http.Client{}, server.URL)
+		gas, err := performGasLeftCall(context.TODO(), &http.Client{}, server.URL, nil)
 
 		assert.Zero(t, gas)
 		assert.Error(t, err)
@@ -50,7 +50,7 @@ func TestPerformGasLeftCallErrors(t *testing.T) {
 		)
 		defer server.Close()
 
-		gas, err := performGasLeftCall(context.TODO(), &http.Client{}, server.URL)
+		gas, err := performGasLeftCall(context.TODO(), &http.Client{}, server.URL, nil)
 
 		assert.Zero(t, gas)
 		assert.Error(t, err)
@@ -75,7 +75,7 @@ func TestPerformGasLeftCallErrors(t *testing.T) {
 		timeout, cancel := context.WithTimeout(context.TODO(), time.Second*1)
 		defer cancel()
 
-		gas, err := performGasLeftCall(timeout, &http.Client{}, server.URL)
+		gas, err := performGasLeftCall(timeout, &http.Client{}, server.URL, nil)
 
 		assert.Zero(t, gas)
 		assert.Error(t, err)
@@ -5,11 +5,12 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sygmaprotocol/rpc-gateway/internal/metrics"
 )
 
 type HealthCheckManagerConfig struct {
@@ -19,60 +20,104 @@ type HealthCheckManagerConfig struct {
 }
 
 type HealthCheckManager struct {
-	hcs    []*HealthChecker
-	logger *slog.Logger
+	hcs         []*HealthChecker
+	logger      *slog.Logger
+	maxBlockLag uint64
+
+	// highest block number reported by a healthy provider and the providers
+	// that are more than maxBlockLag blocks behind it.
+	highestBlockNumber uint64
+	lagging            map[string]bool
+	// health status forced by an operator, by provider name.
+	overrides map[string]bool
+	mu        sync.RWMutex
 
 	metricRPCProviderInfo        *prometheus.GaugeVec
 	metricRPCProviderStatus      *prometheus.GaugeVec
 	metricRPCProviderBlockNumber *prometheus.GaugeVec
 	metricRPCProviderGasLimit    *prometheus.GaugeVec
+	metricRPCProviderBlockLag    *prometheus.GaugeVec
+
+	metricRPCProviderStatusTransitions *prometheus.CounterVec
 }
 
 func NewHealthCheckManager(config HealthCheckManagerConfig, name string) (*HealthCheckManager, error) {
 	hcm := &HealthCheckManager{
-		logger: config.Logger,
-		metricRPCProviderInfo: promauto.NewGaugeVec(
+		logger:      config.Logger,
+		maxBlockLag: config.Config.MaxBlockLag,
+		lagging:     make(map[string]bool),
+		overrides:   make(map[string]bool),
+		metricRPCProviderInfo: metrics.Register(prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "zeroex_rpc_gateway_provider_info_" + name,
 				Help: "Gas limit of a given provider",
 			}, []string{
 				"index",
 				"provider",
-			}),
-		metricRPCProviderStatus: promauto.NewGaugeVec(
+			})),
+		metricRPCProviderStatus: metrics.Register(prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "zeroex_rpc_gateway_provider_status_" + name,
 				Help: "Current status of a given provider by type. Type can be either healthy or tainted.",
 			}, []string{
 				"provider",
 				"type",
-			}),
-		metricRPCProviderBlockNumber: promauto.NewGaugeVec(
+			})),
+		metricRPCProviderBlockNumber: metrics.Register(prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "zeroex_rpc_gateway_provider_block_number_" + name,
 				Help: "Block number of a given provider",
 			}, []string{
 				"provider",
-			}),
-		metricRPCProviderGasLimit: promauto.NewGaugeVec(
+			})),
+		metricRPCProviderGasLimit: metrics.Register(prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "zeroex_rpc_gateway_provider_gasLimit_number_" + name,
 				Help: "Gas limit of a given provider",
 			}, []string{
 				"provider",
-			}),
+			})),
+		metricRPCProviderBlockLag: metrics.Register(prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "zeroex_rpc_gateway_provider_block_lag_" + name,
+				Help: "Number of blocks a given provider is behind the highest known block",
+			}, []string{
+				"provider",
+			})),
+		metricRPCProviderStatusTransitions: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_provider_status_transitions_total_" + name,
+				Help: "The total number of health status changes of a given provider. Status can be either healthy or unhealthy.",
+			}, []string{
+				"provider",
+				"status",
+			})),
 	}
 
 	for _, target := range config.Targets {
+		providerName := target.Name
+		header, err := target.Connection.HTTP.Header()
+		if err != nil {
+			return nil, err
+		}
+
 		hc, err := NewHealthChecker(
 			HealthCheckerConfig{
 				Logger:           config.Logger,
 				URL:              target.Connection.HTTP.URL,
+				Header:           header,
 				Name:             target.Name,
 				Interval:         config.Config.Interval,
 				Timeout:          config.Config.Timeout,
 				FailureThreshold: config.Config.FailureThreshold,
 				SuccessThreshold: config.Config.SuccessThreshold,
+				OnStatusChange: func(healthy bool) {
+					status := "unhealthy"
+					if healthy {
+						status = "healthy"
+					}
+					hcm.metricRPCProviderStatusTransitions.WithLabelValues(providerName, status).Inc()
+				},
 			}, name)
 		if err != nil {
 			return nil, err
@@ -84,6 +129,62 @@ func NewHealthCheckManager(config HealthCheckManagerConfig, name string) (*Healt
 	return hcm, nil
 }
 
+// Inherit carries the results of the health checks of previous, the manager
+// this one replaces when the configuration is reloaded, over to the checkers
+// of the same providers. Without it providers would count as healthy, or
+// not lagging, until they are probed again.
+func (h *HealthCheckManager) Inherit(previous *HealthCheckManager) {
+	for _, hc := range h.hcs {
+		for _, old := range previous.hcs {
+			if old.Name() == hc.Name() && old.config.URL == hc.config.URL {
+				hc.copyFrom(old)
+			}
+		}
+	}
+
+	previous.mu.RLock()
+	defer previous.mu.RUnlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.highestBlockNumber = previous.highestBlockNumber
+	for _, hc := range h.hcs {
+		if lagging, ok := previous.lagging[hc.Name()]; ok {
+			h.lagging[hc.Name()] = lagging
+		}
+	}
+}
+
+// DeleteMetrics deletes the metric series of the provider, which the
+// configuration no longer has.
+func (h *HealthCheckManager) DeleteMetrics(provider string) {
+	for _, vec := range []deletableVec{
+		h.metricRPCProviderInfo,
+		h.metricRPCProviderStatus,
+		h.metricRPCProviderBlockNumber,
+		h.metricRPCProviderGasLimit,
+		h.metricRPCProviderBlockLag,
+		h.metricRPCProviderStatusTransitions,
+	} {
+		vec.DeletePartialMatch(prometheus.Labels{"provider": provider})
+	}
+}
+
+// UnregisterMetrics unregisters the metrics of the manager, whose gateway the
+// configuration no longer has.
+func (h *HealthCheckManager) UnregisterMetrics() {
+	for _, collector := range []prometheus.Collector{
+		h.metricRPCProviderInfo,
+		h.metricRPCProviderStatus,
+		h.metricRPCProviderBlockNumber,
+		h.metricRPCProviderGasLimit,
+		h.metricRPCProviderBlockLag,
+		h.metricRPCProviderStatusTransitions,
+	} {
+		prometheus.DefaultRegisterer.Unregister(collector)
+	}
+}
+
 func (h *HealthCheckManager) runLoop(c context.Context) error {
 	ticker := time.NewTicker(time.Second * 1)
 	defer ticker.Stop()
@@ -93,14 +194,37 @@ func (h *HealthCheckManager) runLoop(c context.Context) error {
 		case <-c.Done():
 			return nil
 		case <-ticker.C:
+			h.updateBlockLag()
 			h.reportStatusMetrics()
 		}
 	}
 }
 
+const (
+	HealthOverrideHealthy   = "healthy"
+	HealthOverrideUnhealthy = "unhealthy"
+	HealthOverrideAuto      = "auto"
+)
+
+// HealthStatus is a snapshot of the health of a provider.
+type HealthStatus struct {
+	Healthy     bool
+	Lagging     bool
+	BlockNumber uint64
+	// Override is either empty or the status forced by SetOverride.
+	Override string
+}
+
 func (h *HealthCheckManager) IsHealthy(name string) bool {
+	h.mu.RLock()
+	healthy, overridden := h.overrides[name]
+	h.mu.RUnlock()
+	if overridden {
+		return healthy
+	}
+
 	for _, hc := range h.hcs {
-		if hc.Name() == name && hc.IsHealthy() {
+		if hc.Name() == name && hc.IsHealthy() && !h.IsLagging(name) {
 			return true
 		}
 	}
@@ -108,6 +232,104 @@ func (h *HealthCheckManager) IsHealthy(name string) bool {
 	return false
 }
 
+// Status returns the health of the provider as seen by the health checks,
+// along with the override forced by an operator, if any.
+func (h *HealthCheckManager) Status(name string) HealthStatus {
+	status := HealthStatus{Lagging: h.IsLagging(name)}
+
+	for _, hc := range h.hcs {
+		if hc.Name() == name {
+			status.Healthy = hc.IsHealthy()
+			status.BlockNumber = hc.BlockNumber()
+		}
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if healthy, ok := h.overrides[name]; ok {
+		status.Override = HealthOverrideUnhealthy
+		if healthy {
+			status.Override = HealthOverrideHealthy
+		}
+	}
+
+	return status
+}
+
+// SetOverride forces the provider to be considered healthy or unhealthy
+// regardless of the health checks. The auto override restores the health
+// checks' verdict.
+func (h *HealthCheckManager) SetOverride(name string, override string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch override {
+	case HealthOverrideHealthy:
+		h.overrides[name] = true
+	case HealthOverrideUnhealthy:
+		h.overrides[name] = false
+	case HealthOverrideAuto:
+		delete(h.overrides, name)
+	default:
+		return fmt.Errorf("unknown health override %q", override)
+	}
+
+	h.logger.Warn("provider health override changed", "provider", name, "override", override)
+
+	return nil
+}
+
+// IsLagging reports whether the provider is more than maxBlockLag blocks
+// behind the highest known block.
+func (h *HealthCheckManager) IsLagging(name string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.lagging[name]
+}
+
+// HighestBlockNumber returns the highest block number reported by a healthy
+// provider, or zero if none is known yet.
+func (h *HealthCheckManager) HighestBlockNumber() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.highestBlockNumber
+}
+
+// updateBlockLag compares the block numbers of all providers and marks the
+// ones falling behind the chain head as lagging.
+func (h *HealthCheckManager) updateBlockLag() {
+	highest := uint64(0)
+	for _, hc := range h.hcs {
+		if hc.IsHealthy() {
+			highest = max(highest, hc.BlockNumber())
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.highestBlockNumber = highest
+
+	for _, hc := range h.hcs {
+		blockNumber := hc.BlockNumber()
+		lag := uint64(0)
+		if blockNumber != 0 && blockNumber < highest {
+			lag = highest - blockNumber
+		}
+		h.metricRPCProviderBlockLag.WithLabelValues(hc.Name()).Set(float64(lag))
+
+		lagging := h.maxBlockLag > 0 && lag > h.maxBlockLag
+		if lagging != h.lagging[hc.Name()] {
+			h.logger.Warn("provider block lag status changed",
+				"provider", hc.Name(), "lagging", lagging, "blockNumber", blockNumber, "highestBlockNumber", highest)
+		}
+		h.lagging[hc.Name()] = lagging
+	}
+}
+
 func (h *HealthCheckManager) reportStatusMetrics() {
 	for _, hc := range h.hcs {
 		if hc.IsHealthy() {
@@ -116,6 +338,12 @@ func (h *HealthCheckManager) reportStatusMetrics() {
 			h.metricRPCProviderStatus.WithLabelValues(hc.Name(), "healthy").Set(0)
 		}
 
+		if h.IsLagging(hc.Name()) {
+			h.metricRPCProviderStatus.WithLabelValues(hc.Name(), "lagging").Set(1)
+		} else {
+			h.metricRPCProviderStatus.WithLabelValues(hc.Name(), "lagging").Set(0)
+		}
+
 		h.metricRPCProviderGasLimit.WithLabelValues(hc.Name()).Set(float64(hc.BlockNumber()))
 		h.metricRPCProviderBlockNumber.WithLabelValues(hc.Name()).Set(float64(hc.BlockNumber()))
 	}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckManagerBlockLag(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var failing atomic.Bool
+	headServer := newFakeBlockNumberServer("0x64", &failing)
+	defer headServer.Close()
+	closeServer := newFakeBlockNumberServer("0x60", &failing)
+	defer closeServer.Close()
+	staleServer := newFakeBlockNumberServer("0x10", &failing)
+	defer staleServer.Close()
+
+	config := createConfig()
+	config.HealthChecks.Timeout = util.DurationUnmarshalled(time.Second)
+	config.HealthChecks.MaxBlockLag = 5
+	config.Targets = []NodeProviderConfig{
+		{Name: "Head", Connection: NodeProviderConnectionConfig{HTTP: NodeProviderConnectionHTTPConfig{URL: headServer.URL}}},
+		{Name: "Close", Connection: NodeProviderConnectionConfig{HTTP: NodeProviderConnectionHTTPConfig{URL: closeServer.URL}}},
+		{Name: "Stale", Connection: NodeProviderConnectionConfig{HTTP: NodeProviderConnectionHTTPConfig{URL: staleServer.URL}}},
+	}
+
+	healthcheckManager, err := NewHealthCheckManager(HealthCheckManagerConfig{
+		Targets: config.Targets,
+		Config:  config.HealthChecks,
+		Logger:  slog.New(slog.NewTextHandler(os.Stderr, nil)),
+	}, "test")
+	assert.NoError(t, err)
+
+	for _, hc := range healthcheckManager.hcs {
+		hc.checkAndSetBlockNumberHealth()
+	}
+	healthcheckManager.updateBlockLag()
+
+	assert.Equal(t, uint64(100), healthcheckManager.HighestBlockNumber())
+	assert.True(t, healthcheckManager.IsHealthy("Head"))
+	assert.True(t, healthcheckManager.IsHealthy("Close"))
+	assert.False(t, healthcheckManager.IsHealthy("Stale"))
+	assert.True(t, healthcheckManager.IsLagging("Stale"))
+}
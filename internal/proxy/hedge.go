@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+const (
+	defaultHedgeDelay       = time.Millisecond * 200
+	defaultHedgeMaxAttempts = 2
+
+	// hedgeLatencySamples is the number of recent response times the
+	// percentile delay is computed from, and hedgeMinLatencySamples the number
+	// needed before it is used instead of the fixed delay.
+	hedgeLatencySamples    = 256
+	hedgeMinLatencySamples = 20
+)
+
+// Read-only methods hedged when no method list is configured.
+var defaultHedgeMethods = []string{ // nolint:gochecknoglobals
+	"eth_blockNumber",
+	"eth_call",
+	"eth_chainId",
+	"eth_estimateGas",
+	"eth_feeHistory",
+	"eth_gasPrice",
+	"eth_getBalance",
+	"eth_getBlockByHash",
+	"eth_getBlockByNumber",
+	"eth_getBlockReceipts",
+	"eth_getCode",
+	"eth_getLogs",
+	"eth_getProof",
+	"eth_getStorageAt",
+	"eth_getTransactionByHash",
+	"eth_getTransactionCount",
+	"eth_getTransactionReceipt",
+	"eth_maxPriorityFeePerGas",
+	"net_version",
+}
+
+// hedger decides when a request is sent to another provider while the
+// previous one has not answered yet.
+type hedger struct {
+	methods     map[string]bool
+	delay       time.Duration
+	percentile  float64
+	maxAttempts int
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newHedger(config HedgeConfig) *hedger {
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = defaultHedgeMethods
+	}
+
+	h := &hedger{
+		methods:     make(map[string]bool, len(methods)),
+		delay:       time.Duration(config.Delay),
+		percentile:  config.Percentile,
+		maxAttempts: config.MaxAttempts,
+		samples:     make([]time.Duration, 0, hedgeLatencySamples),
+	}
+
+	for _, method := range methods {
+		// Sending a transaction twice is never safe, even if configured.
+		if !nonIdempotentMethods[method] {
+			h.methods[method] = true
+		}
+	}
+	if h.delay <= 0 {
+		h.delay = defaultHedgeDelay
+	}
+	if h.percentile < 0 || h.percentile >= 1 {
+		h.percentile = 0
+	}
+	if h.maxAttempts < 2 {
+		h.maxAttempts = defaultHedgeMaxAttempts
+	}
+
+	return h
+}
+
+// Method returns the method of the request if it can be hedged.
+func (h *hedger) Method(body []byte) (string, bool) {
+	requests, isBatch, err := jsonrpc.ParseRequests(body)
+	if err != nil || isBatch || requests[0].IsNotification() || !h.methods[requests[0].Method] {
+		return "", false
+	}
+
+	return requests[0].Method, true
+}
+
+// Observe records the response time of a successful request.
+func (h *hedger) Observe(elapsed time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.samples) < hedgeLatencySamples {
+		h.samples = append(h.samples, elapsed)
+
+		return
+	}
+
+	h.samples[h.next] = elapsed
+	h.next = (h.next + 1) % hedgeLatencySamples
+}
+
+// Delay returns how long to wait for a provider before hedging: the
+// configured percentile of recent response times, or the fixed delay until
+// enough responses were observed.
+func (h *hedger) Delay() time.Duration {
+	if h.percentile == 0 {
+		return h.delay
+	}
+
+	h.mu.Lock()
+	if len(h.samples) < hedgeMinLatencySamples {
+		h.mu.Unlock()
+
+		return h.delay
+	}
+	samples := slices.Clone(h.samples)
+	h.mu.Unlock()
+
+	slices.Sort(samples)
+
+	return samples[int(h.percentile*float64(len(samples)))]
+}
+
+type hedgeResult struct {
+	pw       *ReponseWriter
+	failed   bool
+	rpcError bool
+}
+
+// dispatchHedged behaves like dispatch, except that the request is also sent
+// to the next target when the previous ones have not answered within the
+// hedging delay, up to the maximum number of concurrent attempts. The first
+// successful response wins and the other attempts are cancelled.
+func (p *Proxy) dispatchHedged(r *http.Request, body []byte, method string) (*ReponseWriter, error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	targets := p.healthyTargets(r.Context())
+	results := make(chan hedgeResult, len(targets))
+	next, pending := 0, 0
+
+	launch := func() bool {
+		for next < len(targets) {
+			target := targets[next]
+			next++
+
+			if !p.acquire(target, 1) {
+				continue
+			}
+
+			pending++
+			go func() {
+				results <- p.attempt(ctx, target, r, body)
+			}()
+
+			return true
+		}
+
+		return false
+	}
+
+	if !launch() {
+		return nil, errNoUpstream
+	}
+
+	timer := time.NewTimer(p.hedger.Delay())
+	defer timer.Stop()
+
+	var lastRPCError *ReponseWriter
+	timedOut := false
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if pending < p.hedger.maxAttempts && launch() {
+				p.metricHedgedRequests.WithLabelValues(method).Inc()
+			}
+			timer.Reset(p.hedger.Delay())
+		case result := <-results:
+			pending--
+
+			if !result.failed {
+				return result.pw, nil
+			}
+			if result.rpcError {
+				lastRPCError = result.pw
+			}
+			timedOut = result.pw.timedOut
+
+			// Fail over right away rather than waiting for the delay.
+			if pending == 0 {
+				launch()
+			}
+		}
+	}
+
+	if lastRPCError != nil {
+		return lastRPCError, nil
+	}
+
+	return nil, failure(timedOut)
+}
+
+// attempt forwards the body to the target with its own copy of the request.
+// Attempts cancelled because another one won are not recorded as failures.
+func (p *Proxy) attempt(ctx context.Context, target *NodeProvider, r *http.Request, body []byte) hedgeResult {
+	start := time.Now()
+	pw := p.forward(target, r.Clone(ctx), body)
+
+	if ctx.Err() != nil && r.Context().Err() == nil {
+		target.breaker.Cancel()
+
+		return hedgeResult{pw: pw, failed: true}
+	}
+
+	result := hedgeResult{pw: pw, failed: p.HasNodeProviderFailed(pw.statusCode)}
+	if !result.failed && p.HasJSONRPCFailed(pw) {
+		p.metricRequestErrors.WithLabelValues(target.Name(), "rpc_error").Inc()
+		result.failed = true
+		result.rpcError = true
+	}
+
+	p.observeRequest(target, r, pw.statusCode, start, result.failed)
+	if result.failed {
+		p.metricRequestErrors.WithLabelValues(target.Name(), "rerouted").Inc()
+	} else {
+		p.hedger.Observe(time.Since(start))
+	}
+
+	return result
+}
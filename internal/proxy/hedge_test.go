@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+)
+
+func TestHedgerMethods(t *testing.T) {
+	h := newHedger(HedgeConfig{Methods: []string{"eth_call", "eth_sendRawTransaction"}})
+
+	method, ok := h.Method([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}`))
+	assert.True(t, ok)
+	assert.Equal(t, "eth_call", method)
+
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`,
+		`{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":[]}`,
+		`{"jsonrpc":"2.0","method":"eth_call","params":[]}`,
+		`[{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[]}]`,
+	} {
+		_, ok := h.Method([]byte(body))
+		assert.False(t, ok, body)
+	}
+}
+
+func TestHedgerPercentileDelay(t *testing.T) {
+	h := newHedger(HedgeConfig{
+		Delay:      util.DurationUnmarshalled(time.Second),
+		Percentile: 0.9,
+	})
+
+	for i := 1; i < hedgeMinLatencySamples; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, time.Second, h.Delay())
+
+	for i := 0; i < hedgeLatencySamples; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, 230*time.Millisecond, h.Delay())
+}
+
+func TestHTTPFailoverProxyHedgedRequests(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var cancelled atomic.Bool
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body has to be consumed for the server to notice the client
+		// going away.
+		io.ReadAll(r.Body)
+
+		select {
+		case <-time.After(time.Second):
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"slow"}`))
+		case <-r.Context().Done():
+			cancelled.Store(true)
+		}
+	}))
+	defer slowServer.Close()
+
+	var fastHits atomic.Int64
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastHits.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"fast"}`))
+	}))
+	defer fastServer.Close()
+
+	proxy := createTestProxy(t, func(config *Config) {
+		config.Proxy.UpstreamTimeout = util.DurationUnmarshalled(time.Second * 2)
+		config.Proxy.Hedge = HedgeConfig{
+			Enabled: true,
+			Delay:   util.DurationUnmarshalled(time.Millisecond * 50),
+		}
+	}, slowServer.URL, fastServer.URL)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	start := time.Now()
+	rr := send(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	assert.Less(t, time.Since(start), time.Millisecond*500)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "fast")
+	assert.Equal(t, 1.0, testutil.ToFloat64(proxy.metricHedgedRequests.WithLabelValues("eth_blockNumber")))
+	assert.Eventually(t, cancelled.Load, time.Second, time.Millisecond*10)
+
+	rr = send(`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x00"]}`)
+	assert.Contains(t, rr.Body.String(), "slow")
+	assert.Equal(t, int64(1), fastHits.Load())
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"reflect"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deletableVec is a metric vector whose series can be deleted.
+type deletableVec interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// Inherit carries the runtime state of previous, the proxy this one replaces
+// when the configuration is reloaded, over to it. Only the state of providers
+// with the same name and configuration is kept: their circuit breakers,
+// latency averages, limits and rate limit cooldowns. The cache is kept as long as its
+// configuration did not change. It must be called before the proxy serves
+// requests.
+func (p *Proxy) Inherit(previous *Proxy) {
+	for _, target := range p.allTargets() {
+		old, err := previous.target(target.Name())
+		if err != nil || !reflect.DeepEqual(old.Config, target.Config) {
+			continue
+		}
+
+		if target.breaker != nil && reflect.DeepEqual(p.config.CircuitBreaker, previous.config.CircuitBreaker) {
+			target.breaker = old.breaker
+			p.reportBreakerState(target.Name(), target.breaker.State())
+		}
+		if reflect.DeepEqual(p.config.RateLimitBackoff, previous.config.RateLimitBackoff) {
+			target.cooldown = old.cooldown
+		}
+		// The limits are part of the configuration of the target.
+		target.limits = old.limits
+		target.latency.copyFrom(&old.latency)
+	}
+
+	if p.cache != nil && previous.cache != nil && reflect.DeepEqual(p.config.Cache, previous.config.Cache) {
+		p.cache = previous.cache
+	}
+}
+
+// DeleteMetrics deletes the metric series of the provider, which the
+// configuration no longer has.
+func (p *Proxy) DeleteMetrics(provider string) {
+	for _, vec := range []deletableVec{
+		p.metricRequestDuration,
+		p.metricRequestErrors,
+		p.metricCircuitBreakerState,
+		p.metricCircuitBreakerTransitions,
+		p.metricBroadcastTransactions,
+		p.metricProviderBudgetRemaining,
+		p.metricProviderRateLimited,
+		p.metricProviderCooldownUntil,
+	} {
+		vec.DeletePartialMatch(prometheus.Labels{"provider": provider})
+	}
+
+	p.metricWebSocketReconnects.DeletePartialMatch(prometheus.Labels{"from": provider})
+	p.metricWebSocketReconnects.DeletePartialMatch(prometheus.Labels{"to": provider})
+}
+
+// UnregisterMetrics unregisters the metrics of the proxy, whose gateway the
+// configuration no longer has.
+func (p *Proxy) UnregisterMetrics() {
+	for _, collector := range []prometheus.Collector{
+		p.metricRequestDuration,
+		p.metricRequestErrors,
+		p.metricCacheRequests,
+		p.metricCoalescedRequests,
+		p.metricWebSocketConnections,
+		p.metricWebSocketReconnects,
+		p.metricCircuitBreakerState,
+		p.metricCircuitBreakerTransitions,
+		p.metricHedgedRequests,
+		p.metricBroadcastTransactions,
+		p.metricProviderBudgetRemaining,
+		p.metricProviderRateLimited,
+		p.metricProviderCooldownUntil,
+	} {
+		prometheus.DefaultRegisterer.Unregister(collector)
+	}
+}
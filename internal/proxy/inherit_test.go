@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+)
+
+func TestProxyInherit(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	configure := func(c *Config) {
+		c.Proxy.CircuitBreaker = CircuitBreakerConfig{
+			Enabled:     true,
+			MinRequests: 1,
+			Cooldown:    util.DurationUnmarshalled(time.Hour),
+		}
+		c.Proxy.Cache.Enabled = true
+		c.Targets[0].Limits.DailyBudget = 10
+	}
+
+	previous := createTestProxy(t, configure, "http://a.localhost", "http://b.localhost")
+	for _, target := range previous.allTargets() {
+		target.breaker.Allow()
+		target.breaker.Record(true)
+		target.latency.Observe(time.Second, defaultLatencyAlpha)
+	}
+	previous.hcm.hcs[0].isHealthy = false
+	previous.allTargets()[0].limits.Allow(3)
+
+	// B moved to another URL, its state belongs to the old one.
+	proxy := createTestProxy(t, configure, "http://a.localhost", "http://b2.localhost")
+	proxy.Inherit(previous)
+	proxy.hcm.Inherit(previous.hcm)
+
+	status := proxy.Status()
+	assert.Equal(t, "open", status[0].CircuitBreaker)
+	assert.Equal(t, float64(1000), status[0].LatencyMs)
+	assert.Equal(t, uint64(7), *status[0].BudgetRemaining)
+	assert.False(t, proxy.hcm.IsHealthy("A"))
+
+	assert.Equal(t, "closed", status[1].CircuitBreaker)
+	assert.Zero(t, status[1].LatencyMs)
+	assert.True(t, proxy.hcm.IsHealthy("B"))
+
+	assert.Same(t, previous.cache, proxy.cache)
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultLatencyAlpha        = 0.3
+	defaultLatencyErrorPenalty = time.Second
+)
+
+// ewma is an exponentially weighted moving average of provider response
+// times. The zero value is ready to use and reports no latency until the
+// first sample is observed.
+type ewma struct {
+	mu          sync.RWMutex
+	value       float64
+	initialized bool
+}
+
+// Observe adds a new sample. alpha is the weight of the new sample, between 0
+// and 1; higher values react faster to latency changes.
+func (e *ewma) Observe(sample time.Duration, alpha float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.initialized {
+		e.value = float64(sample)
+		e.initialized = true
+
+		return
+	}
+
+	e.value = alpha*float64(sample) + (1-alpha)*e.value
+}
+
+func (e *ewma) Value() time.Duration {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return time.Duration(e.value)
+}
+
+// copyFrom replaces the average with the one of other.
+func (e *ewma) copyFrom(other *ewma) {
+	other.mu.RLock()
+	value, initialized := other.value, other.initialized
+	other.mu.RUnlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.value, e.initialized = value, initialized
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// NodeProviderLimitsConfig caps the requests sent to a provider, e.g. to stay
+// within the plan of a paid provider. Calls of a batch count as one request
+// each.
+type NodeProviderLimitsConfig struct {
+	// RequestsPerSecond is the rate at which requests can be sent. Zero
+	// disables the limit.
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	// Burst is the number of requests that can be sent at once. Defaults to
+	// RequestsPerSecond rounded up.
+	Burst int `yaml:"burst"`
+	// DailyBudget is the number of requests that can be sent per calendar day
+	// in UTC. Zero disables the budget.
+	DailyBudget uint64 `yaml:"dailyBudget"`
+}
+
+// providerLimiter enforces the limits of a provider. A nil limiter allows
+// everything.
+type providerLimiter struct {
+	rate   *rate.Limiter
+	budget uint64
+	day    time.Time
+	used   uint64
+	mu     sync.Mutex
+	now    func() time.Time
+
+	// onBudgetChange is called with the remaining budget whenever it changes.
+	onBudgetChange func(remaining uint64)
+}
+
+func newProviderLimiter(config NodeProviderLimitsConfig, onBudgetChange func(remaining uint64)) *providerLimiter {
+	if config.RequestsPerSecond <= 0 && config.DailyBudget == 0 {
+		return nil
+	}
+
+	l := &providerLimiter{
+		budget:         config.DailyBudget,
+		now:            time.Now,
+		onBudgetChange: onBudgetChange,
+	}
+
+	if config.RequestsPerSecond > 0 {
+		burst := config.Burst
+		if burst <= 0 {
+			burst = int(math.Ceil(config.RequestsPerSecond))
+		}
+		l.rate = rate.NewLimiter(rate.Limit(config.RequestsPerSecond), burst)
+	}
+
+	if l.budget > 0 {
+		l.mu.Lock()
+		l.rollOver()
+		l.mu.Unlock()
+	}
+
+	return l
+}
+
+// Ready reports whether a request could be sent right now.
+func (l *providerLimiter) Ready() bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.budget > 0 && l.remaining() == 0 {
+		return false
+	}
+
+	return l.rate == nil || l.rate.TokensAt(l.now()) >= 1
+}
+
+// Allow reserves n requests, unless one of the limits would be exceeded.
+// Batches larger than the burst only need the burst to be available, so that
+// they can be sent at all.
+func (l *providerLimiter) Allow(n int) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.budget > 0 && l.remaining() < uint64(n) {
+		return false
+	}
+
+	if l.rate != nil && !l.rate.AllowN(l.now(), min(n, l.rate.Burst())) {
+		return false
+	}
+
+	if l.budget > 0 {
+		l.used += uint64(n)
+		l.onBudgetChange(l.remaining())
+	}
+
+	return true
+}
+
+// Remaining returns the requests left in the daily budget, and false if the
+// provider has no budget.
+func (l *providerLimiter) Remaining() (uint64, bool) {
+	if l == nil || l.budget == 0 {
+		return 0, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.remaining(), true
+}
+
+// remaining returns the requests left in the budget of the current day. The
+// lock must be held.
+func (l *providerLimiter) remaining() uint64 {
+	l.rollOver()
+
+	return l.budget - min(l.used, l.budget)
+}
+
+// rollOver starts a new budget when the day changes. The lock must be held.
+func (l *providerLimiter) rollOver() {
+	now := l.now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if day.Equal(l.day) {
+		return
+	}
+
+	l.day, l.used = day, 0
+	l.onBudgetChange(l.budget)
+}
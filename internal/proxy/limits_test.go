@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderLimiterBudget(t *testing.T) {
+	now := time.Date(2026, 5, 1, 23, 0, 0, 0, time.UTC)
+
+	var remaining []uint64
+	limiter := newProviderLimiter(NodeProviderLimitsConfig{DailyBudget: 5}, func(r uint64) {
+		remaining = append(remaining, r)
+	})
+	limiter.now = func() time.Time { return now }
+	remaining = nil
+
+	assert.True(t, limiter.Allow(3))
+	// A batch is only sent if the whole of it fits in the budget.
+	assert.False(t, limiter.Allow(3))
+	assert.True(t, limiter.Allow(2))
+	assert.False(t, limiter.Ready())
+	assert.False(t, limiter.Allow(1))
+
+	left, ok := limiter.Remaining()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), left)
+
+	// The budget is renewed on the next day.
+	now = now.Add(time.Hour)
+	assert.True(t, limiter.Ready())
+	assert.True(t, limiter.Allow(1))
+
+	assert.Equal(t, []uint64{5, 2, 0, 5, 4}, remaining)
+}
+
+func TestProviderLimiterRate(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newProviderLimiter(NodeProviderLimitsConfig{RequestsPerSecond: 2}, nil)
+	limiter.now = func() time.Time { return now }
+
+	assert.True(t, limiter.Allow(1))
+	assert.True(t, limiter.Ready())
+	assert.True(t, limiter.Allow(1))
+	assert.False(t, limiter.Ready())
+	assert.False(t, limiter.Allow(1))
+
+	// Batches larger than the burst only need the burst.
+	now = now.Add(time.Second)
+	assert.True(t, limiter.Allow(10))
+
+	_, ok := limiter.Remaining()
+	assert.False(t, ok)
+}
+
+func TestNilProviderLimiterAllowsEverything(t *testing.T) {
+	limiter := newProviderLimiter(NodeProviderLimitsConfig{}, nil)
+
+	assert.Nil(t, limiter)
+	assert.True(t, limiter.Ready())
+	assert.True(t, limiter.Allow(100))
+}
+
+func TestHTTPFailoverProxyProviderBudget(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var hitsA, hitsB atomic.Int64
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsA.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)) // nolint:errcheck
+	}))
+	defer serverA.Close()
+
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsB.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)) // nolint:errcheck
+	}))
+	defer serverB.Close()
+
+	proxy := createTestProxy(t, func(config *Config) {
+		config.Targets[0].Limits = NodeProviderLimitsConfig{DailyBudget: 3}
+	}, serverA.URL, serverB.URL)
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(proxy.metricProviderBudgetRemaining.WithLabelValues("A")))
+
+	send := func(body string) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	send(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`)
+	// The batch is charged per call, leaving no room for another one.
+	send(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`)
+	send(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+	send(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)
+
+	assert.Equal(t, int64(2), hitsA.Load())
+	assert.Equal(t, int64(2), hitsB.Load())
+	assert.Equal(t, 0.0, testutil.ToFloat64(proxy.metricProviderBudgetRemaining.WithLabelValues("A")))
+
+	status := proxy.Status()
+	assert.Equal(t, uint64(0), *status[0].BudgetRemaining)
+	assert.Nil(t, status[1].BudgetRemaining)
+}
@@ -1,31 +1,95 @@
 package proxy
 
 import (
+	"encoding/base64"
 	"net/http"
 	"net/http/httputil"
+	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-http-utils/headers"
+	"github.com/pkg/errors"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
 	"github.com/sygmaprotocol/rpc-gateway/internal/middleware"
 )
 
 type NodeProviderConnectionHTTPConfig struct {
 	URL         string `yaml:"url"`
 	Compression bool   `yaml:"compression"`
+	// Headers are sent with every request to the provider, e.g. x-api-key.
+	Headers map[string]string `yaml:"headers"`
+	// BasicAuth and BearerToken set the Authorization header. At most one of
+	// them can be configured.
+	BasicAuth   *BasicAuthConfig `yaml:"basicAuth"`
+	BearerToken string           `yaml:"bearerToken"`
+}
+
+type BasicAuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Header returns the headers sent to the provider. Environment variables in
+// the values, e.g. ${INFURA_SECRET}, are expanded so that secrets can be kept
+// out of the configuration file.
+func (c NodeProviderConnectionHTTPConfig) Header() (http.Header, error) {
+	if c.BasicAuth != nil && c.BearerToken != "" {
+		return nil, errors.New("basicAuth and bearerToken cannot be used together")
+	}
+
+	header := make(http.Header, len(c.Headers)+1)
+	for name, value := range c.Headers {
+		header.Set(name, os.ExpandEnv(value))
+	}
+
+	switch {
+	case c.BasicAuth != nil:
+		credentials := os.ExpandEnv(c.BasicAuth.Username) + ":" + os.ExpandEnv(c.BasicAuth.Password)
+		header.Set(headers.Authorization, "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	case c.BearerToken != "":
+		header.Set(headers.Authorization, "Bearer "+os.ExpandEnv(c.BearerToken))
+	}
+
+	return header, nil
+}
+
+type NodeProviderConnectionWSConfig struct {
+	URL string `yaml:"url"`
 }
 
 type NodeProviderConnectionConfig struct {
 	HTTP NodeProviderConnectionHTTPConfig `yaml:"http"`
+	// WS is optional. Targets without it are not used for websocket clients.
+	WS NodeProviderConnectionWSConfig `yaml:"ws"`
 }
 
 type NodeProviderConfig struct {
 	Name       string                       `yaml:"name"`
 	Connection NodeProviderConnectionConfig `yaml:"connection"`
+	// Weight is used by the weighted-random strategy. Zero counts as 1.
+	Weight uint `yaml:"weight"`
+	// Tier is used by the priority-tiers strategy. Lower tiers are preferred.
+	Tier uint `yaml:"tier"`
+	// Tags describe the capabilities of the provider, e.g. archive or trace,
+	// and are matched by the proxy routes.
+	Tags []string `yaml:"tags"`
+	// Limits cap the requests sent to the provider. When a limit is reached,
+	// requests go to the next provider as if it was unhealthy.
+	Limits NodeProviderLimitsConfig `yaml:"limits"`
 }
 
 type NodeProvider struct {
 	Config NodeProviderConfig
 	Proxy  *httputil.ReverseProxy
+
+	inFlight atomic.Int64
+	latency  ewma
+	cordoned atomic.Bool
+	breaker  *circuitBreaker
+	limits   *providerLimiter
+	cooldown *rateLimitCooldown
 }
 
 func NewNodeProvider(config NodeProviderConfig) (*NodeProvider, error) {
@@ -46,14 +110,45 @@ func (n *NodeProvider) Name() string {
 	return n.Config.Name
 }
 
+func (n *NodeProvider) Weight() uint {
+	if n.Config.Weight == 0 {
+		return 1
+	}
+
+	return n.Config.Weight
+}
+
+// InFlight returns the number of requests and websocket sessions currently
+// proxied to the provider.
+func (n *NodeProvider) InFlight() int64 {
+	return n.inFlight.Load()
+}
+
+// IsCordoned reports whether the provider was taken out of rotation by an
+// operator.
+func (n *NodeProvider) IsCordoned() bool {
+	return n.cordoned.Load()
+}
+
+// Latency returns the moving average of the provider response time, including
+// the penalties for failed requests. It is zero until the first request.
+func (n *NodeProvider) Latency() time.Duration {
+	return n.latency.Value()
+}
+
 func (n *NodeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.inFlight.Add(1)
+	defer n.inFlight.Add(-1)
+
 	gzip := strings.Contains(r.Header.Get(headers.ContentEncoding), "gzip")
 
-	if !n.Config.Connection.HTTP.Compression && gzip {
+	switch {
+	case !n.Config.Connection.HTTP.Compression && gzip:
 		middleware.Gunzip(n.Proxy).ServeHTTP(w, r)
-
-		return
+	case n.Config.Connection.HTTP.Compression && !gzip && jsonrpc.WasGzipEncoded(r):
+		// Requests the client sent compressed were decoded to be handled.
+		middleware.Gzip(n.Proxy).ServeHTTP(w, r)
+	default:
+		n.Proxy.ServeHTTP(w, r)
 	}
-
-	n.Proxy.ServeHTTP(w, r)
 }
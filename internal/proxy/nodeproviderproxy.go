@@ -14,12 +14,20 @@ func NewNodeProviderProxy(config NodeProviderConfig) (*httputil.ReverseProxy, er
 		return nil, errors.Wrap(err, "cannot parse url")
 	}
 
+	header, err := config.Connection.HTTP.Header()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid connection of %s", config.Name)
+	}
+
 	proxy := httputil.NewSingleHostReverseProxy(target)
 	proxy.Director = func(r *http.Request) {
 		r.Host = target.Host
 		r.URL.Scheme = target.Scheme
 		r.URL.Host = target.Host
 		r.URL.Path = target.Path
+		for name, values := range header {
+			r.Header[name] = values
+		}
 	}
 
 	return proxy, nil
@@ -2,29 +2,85 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+	"github.com/sygmaprotocol/rpc-gateway/internal/metrics"
+)
+
+var (
+	errNoUpstream      = errors.New("no healthy upstream available")
+	errUpstreamTimeout = errors.New("upstream request timed out")
 )
 
 type Proxy struct {
+	config ProxyConfig
+	// targets are guarded by mu as their order can be changed at runtime.
 	targets []*NodeProvider
-	hcm     *HealthCheckManager
-	timeout time.Duration
+	// priority is the order last set with SetPriority.
+	priority []string
+	mu       sync.RWMutex
+	hcm      *HealthCheckManager
+	timeout  time.Duration
+	selector Selector
+
+	latencyAlpha        float64
+	latencyErrorPenalty time.Duration
+	rpcErrors           *rpcErrorClassifier
+	batch               BatchConfig
+	cache               *responseCache
+	cachePolicy         cachePolicy
+	coalescer           *coalescer
+	hedger              *hedger
+	router              *methodRouter
+	// handler serves HTTP requests, rejecting methods without route first.
+	handler http.Handler
+
+	broadcastTransactions bool
 
 	metricRequestDuration *prometheus.HistogramVec
 	metricRequestErrors   *prometheus.CounterVec
+	metricCacheRequests   *prometheus.CounterVec
+
+	metricCoalescedRequests    *prometheus.CounterVec
+	metricWebSocketConnections prometheus.Gauge
+	metricWebSocketReconnects  *prometheus.CounterVec
+
+	metricCircuitBreakerState       *prometheus.GaugeVec
+	metricCircuitBreakerTransitions *prometheus.CounterVec
+	metricHedgedRequests            *prometheus.CounterVec
+	metricBroadcastTransactions     *prometheus.CounterVec
+	metricProviderBudgetRemaining   *prometheus.GaugeVec
+	metricProviderRateLimited       *prometheus.CounterVec
+	metricProviderCooldownUntil     *prometheus.GaugeVec
 }
 
 func NewProxy(config Config) (*Proxy, error) {
+	selector, err := NewSelector(config.Proxy.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
 	proxy := &Proxy{
-		hcm:     config.HealthcheckManager,
-		timeout: time.Duration(config.Proxy.UpstreamTimeout),
-		metricRequestDuration: promauto.NewHistogramVec(
+		config:              config.Proxy,
+		hcm:                 config.HealthcheckManager,
+		timeout:             time.Duration(config.Proxy.UpstreamTimeout),
+		selector:            selector,
+		latencyAlpha:        config.Proxy.Latency.Alpha,
+		latencyErrorPenalty: time.Duration(config.Proxy.Latency.ErrorPenalty),
+		rpcErrors:           newRPCErrorClassifier(config.Proxy.FailoverErrors),
+		batch:               config.Proxy.Batch,
+
+		broadcastTransactions: config.Proxy.BroadcastTransactions,
+		metricRequestDuration: metrics.Register(prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name: "zeroex_rpc_gateway_request_duration_seconds_" + config.Name,
 				Help: "Histogram of response time for Gateway in seconds",
@@ -47,15 +103,119 @@ func NewProxy(config Config) (*Proxy, error) {
 				"provider",
 				"method",
 				"status_code",
-			}),
-		metricRequestErrors: promauto.NewCounterVec(
+			})),
+		metricRequestErrors: metrics.Register(prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "zeroex_rpc_gateway_request_errors_handled_total_" + config.Name,
 				Help: "The total number of request errors handled by gateway",
 			}, []string{
 				"provider",
 				"type",
-			}),
+			})),
+		metricCacheRequests: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_cache_requests_total_" + config.Name,
+				Help: "The total number of cacheable requests by result. Result can be either hit or miss.",
+			}, []string{
+				"method",
+				"result",
+			})),
+		metricCoalescedRequests: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_coalesced_requests_total_" + config.Name,
+				Help: "The total number of requests served by an identical request already in flight",
+			}, []string{
+				"method",
+			})),
+		metricWebSocketConnections: metrics.Register(prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "zeroex_rpc_gateway_websocket_connections_" + config.Name,
+				Help: "Number of websocket clients currently connected",
+			})),
+		metricWebSocketReconnects: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_websocket_reconnects_total_" + config.Name,
+				Help: "The total number of websocket sessions moved to another provider after the upstream connection dropped",
+			}, []string{
+				"from",
+				"to",
+			})),
+		metricCircuitBreakerState: metrics.Register(prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "zeroex_rpc_gateway_circuit_breaker_state_" + config.Name,
+				Help: "Current circuit breaker state of a given provider. State can be either closed, open or half-open.",
+			}, []string{
+				"provider",
+				"state",
+			})),
+		metricCircuitBreakerTransitions: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_circuit_breaker_transitions_total_" + config.Name,
+				Help: "The total number of circuit breaker state changes of a given provider",
+			}, []string{
+				"provider",
+				"state",
+			})),
+		metricHedgedRequests: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_hedged_requests_total_" + config.Name,
+				Help: "The total number of requests also sent to another provider because the previous one was slow to answer",
+			}, []string{
+				"method",
+			})),
+		metricBroadcastTransactions: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_broadcast_transactions_total_" + config.Name,
+				Help: "The total number of broadcast transactions by provider and result. Result can be either accepted, known, rejected or failed.",
+			}, []string{
+				"provider",
+				"result",
+			})),
+		metricProviderBudgetRemaining: metrics.Register(prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "zeroex_rpc_gateway_provider_budget_remaining_" + config.Name,
+				Help: "Number of requests left in the daily budget of a given provider",
+			}, []string{
+				"provider",
+			})),
+		metricProviderRateLimited: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_provider_rate_limited_total_" + config.Name,
+				Help: "The total number of 429 Too Many Requests responses of a given provider",
+			}, []string{
+				"provider",
+			})),
+		metricProviderCooldownUntil: metrics.Register(prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "zeroex_rpc_gateway_provider_cooldown_until_seconds_" + config.Name,
+				Help: "Unix time until which a given provider is out of rotation after being rate limited",
+			}, []string{
+				"provider",
+			})),
+	}
+
+	if proxy.latencyAlpha <= 0 || proxy.latencyAlpha > 1 {
+		proxy.latencyAlpha = defaultLatencyAlpha
+	}
+	if proxy.latencyErrorPenalty == 0 {
+		proxy.latencyErrorPenalty = defaultLatencyErrorPenalty
+	}
+
+	if config.Proxy.Cache.Enabled {
+		size := config.Proxy.Cache.Size
+		if size <= 0 {
+			size = defaultCacheSize
+		}
+		proxy.cache = newResponseCache(size)
+		proxy.cachePolicy = newCachePolicy(config.Proxy.Cache)
+	}
+
+	if config.Proxy.CoalesceRequests {
+		proxy.coalescer = newCoalescer()
+	}
+
+	if config.Proxy.Hedge.Enabled {
+		proxy.hedger = newHedger(config.Proxy.Hedge)
 	}
 
 	for _, target := range config.Targets {
@@ -64,16 +224,125 @@ func NewProxy(config Config) (*Proxy, error) {
 			return nil, err
 		}
 
+		if config.Proxy.CircuitBreaker.Enabled {
+			name := p.Name()
+			p.breaker = newCircuitBreaker(config.Proxy.CircuitBreaker, func(state breakerState) {
+				proxy.reportBreakerState(name, state)
+				proxy.metricCircuitBreakerTransitions.WithLabelValues(name, state.String()).Inc()
+			})
+			proxy.reportBreakerState(name, breakerClosed)
+		}
+
+		p.cooldown = newRateLimitCooldown(config.Proxy.RateLimitBackoff)
+
+		name := p.Name()
+		p.limits = newProviderLimiter(target.Limits, func(remaining uint64) {
+			proxy.metricProviderBudgetRemaining.WithLabelValues(name).Set(float64(remaining))
+		})
+
 		proxy.targets = append(proxy.targets, p)
 	}
 
+	proxy.handler = http.HandlerFunc(proxy.serveRequest)
+	if proxy.router = newMethodRouter(config.Proxy.Routes, proxy.targets); proxy.router != nil {
+		proxy.handler = jsonrpc.Filter(proxy.router.Reject, proxy.handler)
+	}
+
 	return proxy, nil
 }
 
+// healthyTargets returns the healthy targets that serve the routes of the
+// request and are neither cordoned, rejected by their circuit breaker, out of
+// their limits nor cooling down after being rate limited, in the order they
+// should be attempted according to the configured strategy.
+func (p *Proxy) healthyTargets(ctx context.Context) []*NodeProvider {
+	targets := p.allTargets()
+	healthy := make([]*NodeProvider, 0, len(targets))
+	for _, target := range targets {
+		if !target.IsCordoned() && target.breaker.Ready() && target.limits.Ready() && target.cooldown.Ready() &&
+			routedTo(ctx, target) && p.hcm.IsHealthy(target.Name()) {
+			healthy = append(healthy, target)
+		}
+	}
+
+	return p.selector.Order(healthy)
+}
+
+// acquire reserves the sending of n requests to the target from its circuit
+// breaker and its limits.
+func (p *Proxy) acquire(target *NodeProvider, n int) bool {
+	if !target.breaker.Allow() {
+		return false
+	}
+
+	if !target.limits.Allow(n) {
+		target.breaker.Cancel()
+
+		return false
+	}
+
+	return true
+}
+
+// callCount returns the number of calls in the body, as charged against the
+// limits of providers.
+func callCount(body []byte) int {
+	requests, _, err := jsonrpc.ParseRequests(body)
+	if err != nil || len(requests) == 0 {
+		return 1
+	}
+
+	return len(requests)
+}
+
 func (p *Proxy) HasNodeProviderFailed(statusCode int) bool {
 	return statusCode >= http.StatusInternalServerError || statusCode == http.StatusTooManyRequests
 }
 
+// HasJSONRPCFailed reports whether a successful HTTP response carries a
+// JSON-RPC error that indicates a provider failure, e.g. a rate limit.
+func (p *Proxy) HasJSONRPCFailed(pw *ReponseWriter) bool {
+	body, err := pw.DecodedBody()
+	if err != nil {
+		return false
+	}
+
+	return p.rpcErrors.HasFailed(body)
+}
+
+// observeRequest records the outcome of a request sent to the target in the
+// Prometheus histogram, the target's latency average and its circuit breaker.
+// Requests that failed because the client went away say nothing about the
+// target, so they are left out of its latency and circuit breaker.
+func (p *Proxy) observeRequest(target *NodeProvider, r *http.Request, statusCode int, start time.Time, failed bool) {
+	elapsed := time.Since(start)
+
+	p.metricRequestDuration.WithLabelValues(target.Name(), r.Method, strconv.Itoa(statusCode)).
+		Observe(elapsed.Seconds())
+
+	if failed && r.Context().Err() != nil {
+		target.breaker.Cancel()
+
+		return
+	}
+
+	if failed {
+		elapsed += p.latencyErrorPenalty
+	}
+	target.latency.Observe(elapsed, p.latencyAlpha)
+	target.breaker.Record(failed)
+}
+
+func (p *Proxy) reportBreakerState(provider string, state breakerState) {
+	for _, s := range []breakerState{breakerClosed, breakerOpen, breakerHalfOpen} {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		p.metricCircuitBreakerState.WithLabelValues(provider, s.String()).Set(value)
+	}
+}
+
 func (p *Proxy) copyHeaders(dst http.ResponseWriter, src http.ResponseWriter) {
 	for k, v := range src.Header() {
 		if len(v) == 0 {
@@ -84,6 +353,13 @@ func (p *Proxy) copyHeaders(dst http.ResponseWriter, src http.ResponseWriter) {
 	}
 }
 
+func (p *Proxy) writeResponse(w http.ResponseWriter, pw *ReponseWriter) {
+	p.copyHeaders(w, pw)
+
+	w.WriteHeader(pw.statusCode)
+	w.Write(pw.body.Bytes()) // nolint:errcheck
+}
+
 func (p *Proxy) timeoutHandler(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		handler := http.TimeoutHandler(next, p.timeout, http.StatusText(http.StatusGatewayTimeout))
@@ -93,47 +369,169 @@ func (p *Proxy) timeoutHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-func (p *Proxy) errServiceUnavailable(w http.ResponseWriter) {
-	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+// writeError answers the requests of the body with a JSON-RPC error for the
+// reason no upstream response could be returned.
+func (p *Proxy) writeError(w http.ResponseWriter, body []byte, err error) {
+	if errors.Is(err, errUpstreamTimeout) {
+		jsonrpc.WriteError(w, http.StatusGatewayTimeout, body, jsonrpc.CodeUpstreamTimeout, err.Error())
+
+		return
+	}
+
+	jsonrpc.WriteError(w, http.StatusServiceUnavailable, body, jsonrpc.CodeNoUpstream, errNoUpstream.Error())
+}
+
+// failure returns the error for requests no target answered.
+func failure(timedOut bool) error {
+	if timedOut {
+		return errUpstreamTimeout
+	}
+
+	return errNoUpstream
+}
+
+// forward sends the body to the target and returns the buffered response.
+func (p *Proxy) forward(target *NodeProvider, r *http.Request, body []byte) *ReponseWriter {
+	ctx, cancel := context.WithTimeout(r.Context(), p.timeout)
+	defer cancel()
+
+	pw := NewResponseWriter()
+	r = r.WithContext(ctx)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	p.timeoutHandler(target).ServeHTTP(pw, r)
+	pw.timedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	p.observeRateLimit(target, pw)
+
+	return pw
+}
+
+// observeRateLimit takes the target out of rotation when it is rate limited.
+func (p *Proxy) observeRateLimit(target *NodeProvider, pw *ReponseWriter) {
+	if target.cooldown == nil || pw.timedOut {
+		return
+	}
+
+	if pw.statusCode != http.StatusTooManyRequests {
+		target.cooldown.Reset()
+
+		return
+	}
+
+	wait := retryAfter(pw.header, time.Now())
+	until := target.cooldown.Trip(wait)
+
+	p.metricProviderRateLimited.WithLabelValues(target.Name()).Inc()
+	p.metricProviderCooldownUntil.WithLabelValues(target.Name()).Set(float64(until.Unix()))
+	p.hcm.logger.Warn("provider rate limited, taken out of rotation",
+		"provider", target.Name(), "until", until, "retryAfter", wait)
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	body := &bytes.Buffer{}
+	if websocket.IsWebSocketUpgrade(r) {
+		p.serveWebSocket(w, r)
+
+		return
+	}
+
+	p.handler.ServeHTTP(w, r)
+}
+
+func (p *Proxy) serveRequest(w http.ResponseWriter, r *http.Request) {
+	// Gzip encoded bodies are decoded once here, so that they are handled
+	// like the others. Targets supporting compression get them encoded again.
+	body, err := jsonrpc.ReadBody(r)
+	if err != nil {
+		jsonrpc.WriteError(w, http.StatusBadRequest, nil, jsonrpc.CodeInvalidRequest, "failed to read request body")
+
+		return
+	}
+
+	if p.router != nil {
+		if requests, _, err := jsonrpc.ParseRequests(body); err == nil {
+			r = r.WithContext(p.router.withRoutes(r.Context(), requests))
+		}
+	}
+
+	if p.cache != nil && p.serveCached(w, r, body) {
+		return
+	}
+
+	if jsonrpc.IsBatch(body) {
+		p.serveBatch(w, r, body)
 
-	if _, err := io.Copy(body, r.Body); err != nil {
-		p.errServiceUnavailable(w)
+		return
+	}
+
+	p.serve(w, r, body)
+}
+
+// serve forwards the body to the healthy targets and writes the response.
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request, body []byte) {
+	pw, err := p.dispatchCoalesced(r, body)
+	if err != nil {
+		p.writeError(w, body, err)
 
 		return
 	}
 
-	for _, target := range p.targets {
-		if !p.hcm.IsHealthy(target.Name()) {
+	p.writeResponse(w, pw)
+}
+
+// dispatch forwards the body as-is to the healthy targets until one of them
+// succeeds. When no provider succeeds, the last response carrying a JSON-RPC
+// error is returned, as it is more useful to the client than a gateway error.
+// It returns an error if there is no response at all.
+func (p *Proxy) dispatch(r *http.Request, body []byte) (*ReponseWriter, error) {
+	if request, ok := p.broadcastRequest(body); ok {
+		return p.dispatchBroadcast(r, body, request)
+	}
+
+	if p.hedger != nil {
+		if method, ok := p.hedger.Method(body); ok {
+			return p.dispatchHedged(r, body, method)
+		}
+	}
+
+	var lastRPCError *ReponseWriter
+	timedOut := false
+	calls := callCount(body)
+
+	for _, target := range p.healthyTargets(r.Context()) {
+		if !p.acquire(target, calls) {
 			continue
 		}
-		start := time.Now()
 
-		pw := NewResponseWriter()
-		r.Body = io.NopCloser(bytes.NewBuffer(body.Bytes()))
+		start := time.Now()
+		pw := p.forward(target, r, body)
 
-		p.timeoutHandler(target).ServeHTTP(pw, r)
+		failed := p.HasNodeProviderFailed(pw.statusCode)
+		if !failed && p.HasJSONRPCFailed(pw) {
+			p.metricRequestErrors.WithLabelValues(target.Name(), "rpc_error").Inc()
+			lastRPCError = pw
+			failed = true
+		}
 
-		if p.HasNodeProviderFailed(pw.statusCode) {
-			p.metricRequestDuration.WithLabelValues(target.Name(), r.Method, strconv.Itoa(pw.statusCode)).
-				Observe(time.Since(start).Seconds())
+		if failed {
+			p.observeRequest(target, r, pw.statusCode, start, true)
+			if r.Context().Err() != nil {
+				break
+			}
 			p.metricRequestErrors.WithLabelValues(target.Name(), "rerouted").Inc()
+			timedOut = pw.timedOut
 
 			continue
 		}
-		p.copyHeaders(w, pw)
 
-		w.WriteHeader(pw.statusCode)
-		w.Write(pw.body.Bytes()) // nolint:errcheck
+		p.observeRequest(target, r, pw.statusCode, start, false)
 
-		p.metricRequestDuration.WithLabelValues(target.Name(), r.Method, strconv.Itoa(pw.statusCode)).
-			Observe(time.Since(start).Seconds())
+		return pw, nil
+	}
 
-		return
+	if lastRPCError != nil {
+		return lastRPCError, nil
 	}
 
-	p.errServiceUnavailable(w)
+	return nil, failure(timedOut)
 }
@@ -9,6 +9,8 @@ import (
 	"net/http/httptest"
 	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -35,6 +37,42 @@ func createConfig() Config {
 	}
 }
 
+// createTestProxy creates a proxy with a target per URL, named A, B, C...
+// The configure function, if any, can adjust the configuration beforehand.
+func createTestProxy(t *testing.T, configure func(*Config), urls ...string) *Proxy {
+	t.Helper()
+
+	rpcGatewayConfig := createConfig()
+	for i, url := range urls {
+		rpcGatewayConfig.Targets = append(rpcGatewayConfig.Targets, NodeProviderConfig{
+			Name: string(rune('A' + i)),
+			Connection: NodeProviderConnectionConfig{
+				HTTP: NodeProviderConnectionHTTPConfig{
+					URL: url,
+				},
+			},
+		})
+	}
+
+	if configure != nil {
+		configure(&rpcGatewayConfig)
+	}
+
+	healthcheckManager, err := NewHealthCheckManager(HealthCheckManagerConfig{
+		Targets: rpcGatewayConfig.Targets,
+		Config:  rpcGatewayConfig.HealthChecks,
+		Logger:  slog.New(slog.NewTextHandler(os.Stderr, nil)),
+	}, "test")
+	assert.NoError(t, err)
+
+	rpcGatewayConfig.HealthcheckManager = healthcheckManager
+
+	proxy, err := NewProxy(rpcGatewayConfig)
+	assert.NoError(t, err)
+
+	return proxy
+}
+
 func TestHttpFailoverProxyRerouteRequests(t *testing.T) {
 	prometheus.DefaultRegisterer = prometheus.NewRegistry()
 
@@ -226,6 +264,13 @@ func TestHttpFailoverProxyWithCompressionSupportedTarget(t *testing.T) {
 
 	assert.NoError(t, g.Close())
 	assert.Equal(t, wantBody.Bytes(), receivedBody)
+
+	// Plain requests are forwarded as they are.
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"body": "content"}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "", receivedHeaderContentEncoding)
+	assert.Equal(t, `{"body": "content"}`, string(receivedBody))
 }
 
 func TestHTTPFailoverProxyWhenCannotConnectToPrimaryProvider(t *testing.T) {
@@ -291,3 +336,251 @@ func TestHTTPFailoverProxyWhenCannotConnectToPrimaryProvider(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rr.Code)
 	assert.Equal(t, `{"this_is": "body"}`, rr.Body.String())
 }
+
+func TestHTTPFailoverProxyLatencyStrategy(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	fakeSlowRPCServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("slow"))
+	}))
+	defer fakeSlowRPCServer.Close()
+
+	fakeFastRPCServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fakeFastRPCServer.Close()
+
+	rpcGatewayConfig := createConfig()
+	rpcGatewayConfig.Proxy.Strategy = StrategyLatency
+	rpcGatewayConfig.Targets = []NodeProviderConfig{
+		{
+			Name: "Slow",
+			Connection: NodeProviderConnectionConfig{
+				HTTP: NodeProviderConnectionHTTPConfig{
+					URL: fakeSlowRPCServer.URL,
+				},
+			},
+		},
+		{
+			Name: "Fast",
+			Connection: NodeProviderConnectionConfig{
+				HTTP: NodeProviderConnectionHTTPConfig{
+					URL: fakeFastRPCServer.URL,
+				},
+			},
+		},
+	}
+	healthcheckManager, err := NewHealthCheckManager(HealthCheckManagerConfig{
+		Targets: rpcGatewayConfig.Targets,
+		Config:  rpcGatewayConfig.HealthChecks,
+		Logger:  slog.New(slog.NewTextHandler(os.Stderr, nil)),
+	}, "test")
+	assert.NoError(t, err)
+
+	rpcGatewayConfig.HealthcheckManager = healthcheckManager
+
+	httpFailoverProxy, err := NewProxy(rpcGatewayConfig)
+	assert.NoError(t, err)
+
+	responses := map[string]int{}
+	for i := 0; i < 5; i++ {
+		req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{}`))
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		httpFailoverProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		responses[rr.Body.String()]++
+	}
+
+	// Both providers are measured once, afterwards the fast one is preferred.
+	assert.Equal(t, 1, responses["slow"])
+	assert.Equal(t, 4, responses["fast"])
+}
+
+func TestHTTPFailoverProxyRerouteJSONRPCErrors(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	fakeRateLimitedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}}`))
+	}))
+	defer fakeRateLimitedServer.Close()
+
+	fakeRevertingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"execution reverted"}}`))
+	}))
+	defer fakeRevertingServer.Close()
+
+	rpcGatewayConfig := createConfig()
+	rpcGatewayConfig.Targets = []NodeProviderConfig{
+		{
+			Name: "RateLimited",
+			Connection: NodeProviderConnectionConfig{
+				HTTP: NodeProviderConnectionHTTPConfig{
+					URL: fakeRateLimitedServer.URL,
+				},
+			},
+		},
+		{
+			Name: "Reverting",
+			Connection: NodeProviderConnectionConfig{
+				HTTP: NodeProviderConnectionHTTPConfig{
+					URL: fakeRevertingServer.URL,
+				},
+			},
+		},
+	}
+	healthcheckManager, err := NewHealthCheckManager(HealthCheckManagerConfig{
+		Targets: rpcGatewayConfig.Targets,
+		Config:  rpcGatewayConfig.HealthChecks,
+		Logger:  slog.New(slog.NewTextHandler(os.Stderr, nil)),
+	}, "test")
+	assert.NoError(t, err)
+
+	rpcGatewayConfig.HealthcheckManager = healthcheckManager
+
+	httpFailoverProxy, err := NewProxy(rpcGatewayConfig)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"jsonrpc":"2.0","id":1,"method":"eth_call"}`))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	httpFailoverProxy.ServeHTTP(rr, req)
+
+	// The rate limit is rerouted, the revert is passed through.
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "execution reverted")
+}
+
+func TestHTTPFailoverProxySkipsCordonedTargets(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	var hitsA, hitsB atomic.Int64
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsA.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer serverA.Close()
+
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsB.Add(1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer serverB.Close()
+
+	proxy := createTestProxy(t, nil, serverA.URL, serverB.URL)
+
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	assert.NoError(t, proxy.Cordon("A", true))
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, int64(0), hitsA.Load())
+	assert.Equal(t, int64(1), hitsB.Load())
+
+	assert.NoError(t, proxy.Cordon("A", false))
+	assert.NoError(t, proxy.SetPriority([]string{"B", "A"}))
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, int64(2), hitsB.Load())
+
+	assert.Error(t, proxy.Cordon("C", true))
+	assert.Error(t, proxy.SetPriority([]string{"C"}))
+}
+
+func TestHTTPFailoverProxyJSONRPCErrors(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	send := func(proxy *Proxy, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	proxy := createTestProxy(t, nil, failing.URL)
+
+	rr := send(proxy, `{"jsonrpc":"2.0","id":42,"method":"eth_chainId"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":42,"error":{"code":-32050,"message":"no healthy upstream available"}}`, rr.Body.String())
+
+	rr = send(proxy, `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `[
+		{"jsonrpc":"2.0","id":1,"error":{"code":-32050,"message":"no healthy upstream available"}},
+		{"jsonrpc":"2.0","id":2,"error":{"code":-32050,"message":"no healthy upstream available"}}
+	]`, rr.Body.String())
+
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	proxy = createTestProxy(t, func(config *Config) {
+		config.Proxy.UpstreamTimeout = util.DurationUnmarshalled(time.Millisecond * 50)
+	}, slow.URL)
+
+	rr = send(proxy, `{"jsonrpc":"2.0","id":"x","method":"eth_chainId"}`)
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"x","error":{"code":-32051,"message":"upstream request timed out"}}`, rr.Body.String())
+}
+
+func TestHTTPFailoverProxyUpstreamHeaders(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	t.Setenv("TEST_PROVIDER_KEY", "key")
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	proxy := createTestProxy(t, func(config *Config) {
+		config.Targets[0].Connection.HTTP.Headers = map[string]string{"X-Api-Key": "${TEST_PROVIDER_KEY}"}
+		config.Targets[0].Connection.HTTP.BearerToken = "token"
+	}, server.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`))
+	req.Header.Set(headers.Authorization, "Bearer client")
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "key", received.Get("X-Api-Key"))
+	assert.Equal(t, "Bearer token", received.Get(headers.Authorization))
+}
+
+func TestNodeProviderConnectionHeader(t *testing.T) {
+	config := NodeProviderConnectionHTTPConfig{
+		BasicAuth: &BasicAuthConfig{Username: "user", Password: "pass"},
+	}
+	header, err := config.Header()
+	assert.NoError(t, err)
+	assert.Equal(t, "Basic dXNlcjpwYXNz", header.Get(headers.Authorization))
+
+	config.BearerToken = "token"
+	_, err = config.Header()
+	assert.Error(t, err)
+
+	_, err = NewNodeProviderProxy(NodeProviderConfig{Connection: NodeProviderConnectionConfig{HTTP: config}})
+	assert.Error(t, err)
+}
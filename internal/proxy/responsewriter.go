@@ -2,13 +2,20 @@ package proxy
 
 import (
 	"bytes"
+	"compress/gzip"
+	"io"
 	"net/http"
+	"strings"
+
+	"github.com/go-http-utils/headers"
 )
 
 type ReponseWriter struct {
 	body       *bytes.Buffer
 	header     http.Header
 	statusCode int
+	// timedOut reports whether the upstream did not answer in time.
+	timedOut bool
 }
 
 func (p *ReponseWriter) Header() http.Header {
@@ -29,3 +36,19 @@ func NewResponseWriter() *ReponseWriter {
 		body:   &bytes.Buffer{},
 	}
 }
+
+// DecodedBody returns the body without the content encoding applied by the
+// upstream, so it can be inspected.
+func (p *ReponseWriter) DecodedBody() ([]byte, error) {
+	if !strings.Contains(p.header.Get(headers.ContentEncoding), "gzip") {
+		return p.body.Bytes(), nil
+	}
+
+	g, err := gzip.NewReader(bytes.NewReader(p.body.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	defer g.Close()
+
+	return io.ReadAll(g)
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+type routesKey struct{}
+
+// methodRoute sends the methods matching one of its patterns to the targets
+// having one of its tags.
+type methodRoute struct {
+	patterns []string
+	tags     []string
+	// served reports whether any target has one of the tags.
+	served bool
+}
+
+func (r *methodRoute) Serves(target *NodeProvider) bool {
+	for _, tag := range r.tags {
+		if slices.Contains(target.Config.Tags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// methodRouter resolves the route of JSON-RPC methods. Routes are matched in
+// order and methods matching no route are served by every target.
+type methodRouter struct {
+	routes []*methodRoute
+}
+
+func newMethodRouter(configs []RouteConfig, targets []*NodeProvider) *methodRouter {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	router := &methodRouter{}
+	for _, config := range configs {
+		route := &methodRoute{
+			patterns: config.Methods,
+			tags:     config.Tags,
+		}
+		for _, target := range targets {
+			route.served = route.served || route.Serves(target)
+		}
+
+		router.routes = append(router.routes, route)
+	}
+
+	return router
+}
+
+func (m *methodRouter) Route(method string) *methodRoute {
+	for _, route := range m.routes {
+		for _, pattern := range route.patterns {
+			if jsonrpc.MatchMethod(pattern, method) {
+				return route
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reject returns an error for requests whose route has no target.
+func (m *methodRouter) Reject(request jsonrpc.Request) *jsonrpc.Error {
+	if route := m.Route(request.Method); route != nil && !route.served {
+		return &jsonrpc.Error{
+			Code:    jsonrpc.CodeMethodNotFound,
+			Message: fmt.Sprintf("the method %s does not exist/is not available", request.Method),
+		}
+	}
+
+	return nil
+}
+
+// ServesAll reports whether the target serves every route that has targets.
+func (m *methodRouter) ServesAll(target *NodeProvider) bool {
+	if m == nil {
+		return true
+	}
+
+	for _, route := range m.routes {
+		if route.served && !route.Serves(target) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withRoutes stores the routes of the requests in the context, so that only
+// the targets serving all of them are selected.
+func (m *methodRouter) withRoutes(ctx context.Context, requests []jsonrpc.Request) context.Context {
+	var routes []*methodRoute
+	for _, request := range requests {
+		if route := m.Route(request.Method); route != nil && !slices.Contains(routes, route) {
+			routes = append(routes, route)
+		}
+	}
+
+	return context.WithValue(ctx, routesKey{}, routes)
+}
+
+// routedTo reports whether the target serves the routes of the request.
+func routedTo(ctx context.Context, target *NodeProvider) bool {
+	routes, _ := ctx.Value(routesKey{}).([]*methodRoute)
+	for _, route := range routes {
+		if !route.Serves(target) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPFailoverProxyMethodRouting(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.ReadAll(r.Body)
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + name + `"}`))
+		}))
+	}
+
+	full := newServer("full")
+	defer full.Close()
+	archive := newServer("archive")
+	defer archive.Close()
+
+	proxy := createTestProxy(t, func(config *Config) {
+		config.Targets[1].Tags = []string{"archive"}
+		config.Proxy.Routes = []RouteConfig{
+			{Methods: []string{"eth_getProof", "debug_*"}, Tags: []string{"archive"}},
+			{Methods: []string{"trace_*"}, Tags: []string{"trace"}},
+			{Methods: []string{"admin_*"}},
+		}
+	}, full.URL, archive.URL)
+
+	send := func(body string) string {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+
+		return rr.Body.String()
+	}
+
+	assert.Contains(t, send(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`), "full")
+	assert.Contains(t, send(`{"jsonrpc":"2.0","id":1,"method":"eth_getProof"}`), "archive")
+	assert.Contains(t, send(`{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction"}`), "archive")
+	// A batch goes to the targets serving all of its methods.
+	assert.Contains(t, send(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_getProof"}]`), "archive")
+
+	for _, method := range []string{"trace_block", "admin_peers"} {
+		assert.JSONEq(t,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"the method `+method+` does not exist/is not available"}}`,
+			send(`{"jsonrpc":"2.0","id":1,"method":"`+method+`"}`))
+	}
+}
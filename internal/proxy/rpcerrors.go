@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+// Errors returned by providers with HTTP 200 that indicate a problem on the
+// provider side rather than with the request itself.
+var (
+	defaultFailoverErrorCodes = []int{ // nolint:gochecknoglobals
+		-32005, // limit exceeded
+		-32603, // internal error
+	}
+	defaultFailoverErrorMessages = []string{ // nolint:gochecknoglobals
+		"header not found",
+		"limit exceeded",
+		"rate limit",
+		"too many requests",
+		"missing trie node",
+	}
+)
+
+// executionRevertedCode is the code used by clients for reverted calls. Such
+// errors are a genuine result and are always passed through.
+const executionRevertedCode = 3
+
+// rpcErrorClassifier decides which JSON-RPC errors are treated as provider
+// failures and trigger rerouting.
+type rpcErrorClassifier struct {
+	codes    map[int]bool
+	messages []string
+}
+
+func newRPCErrorClassifier(config FailoverErrorsConfig) *rpcErrorClassifier {
+	codes := config.Codes
+	messages := config.Messages
+	if len(codes) == 0 && len(messages) == 0 {
+		codes = defaultFailoverErrorCodes
+		messages = defaultFailoverErrorMessages
+	}
+
+	classifier := &rpcErrorClassifier{
+		codes: make(map[int]bool, len(codes)),
+	}
+	for _, code := range codes {
+		classifier.codes[code] = true
+	}
+	for _, message := range messages {
+		classifier.messages = append(classifier.messages, strings.ToLower(message))
+	}
+
+	return classifier
+}
+
+// IsFailure reports whether the error should be treated as a provider failure.
+func (c *rpcErrorClassifier) IsFailure(rpcErr *jsonrpc.Error) bool {
+	if rpcErr == nil {
+		return false
+	}
+
+	message := strings.ToLower(rpcErr.Message)
+	if rpcErr.Code == executionRevertedCode || strings.Contains(message, "execution reverted") {
+		return false
+	}
+
+	if c.codes[rpcErr.Code] {
+		return true
+	}
+
+	for _, m := range c.messages {
+		if strings.Contains(message, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasFailed reports whether any of the responses in the body carries an error
+// that should be treated as a provider failure. Bodies that are not JSON-RPC
+// responses are never considered failed.
+func (c *rpcErrorClassifier) HasFailed(body []byte) bool {
+	responses, _, err := jsonrpc.ParseResponses(body)
+	if err != nil {
+		return false
+	}
+
+	for _, response := range responses {
+		if c.IsFailure(response.Error) {
+			return true
+		}
+	}
+
+	return false
+}
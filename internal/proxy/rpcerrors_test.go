@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRPCErrorClassifier(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		config FailoverErrorsConfig
+		body   string
+		failed bool
+	}{
+		{
+			name: "result",
+			body: `{"jsonrpc":"2.0","id":1,"result":"0x1"}`,
+		},
+		{
+			name:   "rate limit code",
+			body:   `{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}}`,
+			failed: true,
+		},
+		{
+			name:   "header not found message",
+			body:   `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}`,
+			failed: true,
+		},
+		{
+			name: "execution reverted",
+			body: `{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"execution reverted","data":"0x"}}`,
+		},
+		{
+			name: "execution reverted with internal error code",
+			body: `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"execution reverted: paused"}}`,
+		},
+		{
+			name:   "batch with a single failure",
+			body:   `[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"error":{"code":-32005,"message":"rate"}}]`,
+			failed: true,
+		},
+		{
+			name: "not JSON-RPC",
+			body: `OK`,
+		},
+		{
+			name:   "custom code",
+			config: FailoverErrorsConfig{Codes: []int{-32099}},
+			body:   `{"jsonrpc":"2.0","id":1,"error":{"code":-32099,"message":"custom"}}`,
+			failed: true,
+		},
+		{
+			name:   "custom config replaces defaults",
+			config: FailoverErrorsConfig{Codes: []int{-32099}},
+			body:   `{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.failed, newRPCErrorClassifier(tt.config).HasFailed([]byte(tt.body)))
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StrategyFailover       = "failover"
+	StrategyRoundRobin     = "round-robin"
+	StrategyWeightedRandom = "weighted-random"
+	StrategyLeastInFlight  = "least-in-flight"
+	StrategyPriorityTiers  = "priority-tiers"
+	StrategyLatency        = "latency"
+)
+
+// Selector decides in which order the healthy node providers are attempted
+// for a single request. The first provider is the preferred one, the rest
+// are used for failover. Implementations must not modify the input slice.
+type Selector interface {
+	Order(targets []*NodeProvider) []*NodeProvider
+}
+
+// NewSelector returns the Selector for the given strategy name. An empty name
+// falls back to the failover strategy.
+func NewSelector(strategy string) (Selector, error) {
+	switch strategy {
+	case "", StrategyFailover:
+		return &failoverSelector{}, nil
+	case StrategyRoundRobin:
+		return &roundRobinSelector{}, nil
+	case StrategyWeightedRandom:
+		return &weightedRandomSelector{}, nil
+	case StrategyLeastInFlight:
+		return &leastInFlightSelector{}, nil
+	case StrategyPriorityTiers:
+		return &priorityTiersSelector{}, nil
+	case StrategyLatency:
+		return &latencySelector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown proxy strategy %q", strategy)
+	}
+}
+
+// failoverSelector keeps the configured order, so all the traffic goes to the
+// first healthy provider.
+type failoverSelector struct{}
+
+func (s *failoverSelector) Order(targets []*NodeProvider) []*NodeProvider {
+	return append([]*NodeProvider(nil), targets...)
+}
+
+// roundRobinSelector rotates the preferred provider on every request.
+type roundRobinSelector struct {
+	next atomic.Uint64
+}
+
+func (s *roundRobinSelector) Order(targets []*NodeProvider) []*NodeProvider {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	return rotate(targets, int((s.next.Add(1)-1)%uint64(len(targets))))
+}
+
+// weightedRandomSelector picks providers at random, proportionally to their
+// configured weight. Providers without a weight count as weight 1.
+type weightedRandomSelector struct{}
+
+func (s *weightedRandomSelector) Order(targets []*NodeProvider) []*NodeProvider {
+	remaining := append([]*NodeProvider(nil), targets...)
+	ordered := make([]*NodeProvider, 0, len(targets))
+
+	for len(remaining) > 0 {
+		total := uint(0)
+		for _, target := range remaining {
+			total += target.Weight()
+		}
+
+		pick := uint(rand.Int63n(int64(total))) // nolint:gosec
+		i := 0
+		for ; i < len(remaining)-1; i++ {
+			if pick < remaining[i].Weight() {
+				break
+			}
+			pick -= remaining[i].Weight()
+		}
+
+		ordered = append(ordered, remaining[i])
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+
+	return ordered
+}
+
+// leastInFlightSelector prefers the provider with the fewest requests
+// currently being proxied. Ties keep the configured order.
+type leastInFlightSelector struct{}
+
+func (s *leastInFlightSelector) Order(targets []*NodeProvider) []*NodeProvider {
+	inFlight := make(map[*NodeProvider]int64, len(targets))
+	for _, target := range targets {
+		inFlight[target] = target.InFlight()
+	}
+
+	ordered := append([]*NodeProvider(nil), targets...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return inFlight[ordered[i]] < inFlight[ordered[j]]
+	})
+
+	return ordered
+}
+
+// priorityTiersSelector groups providers by their tier, lowest first, and
+// round-robins between the providers of the same tier.
+type priorityTiersSelector struct {
+	next atomic.Uint64
+}
+
+func (s *priorityTiersSelector) Order(targets []*NodeProvider) []*NodeProvider {
+	ordered := append([]*NodeProvider(nil), targets...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Config.Tier < ordered[j].Config.Tier
+	})
+
+	offset := s.next.Add(1) - 1
+	for start := 0; start < len(ordered); {
+		end := start + 1
+		for end < len(ordered) && ordered[end].Config.Tier == ordered[start].Config.Tier {
+			end++
+		}
+
+		copy(ordered[start:end], rotate(ordered[start:end], int(offset%uint64(end-start))))
+		start = end
+	}
+
+	return ordered
+}
+
+// latencyProbeInterval is how often, in requests, the latency strategy
+// sends a request to another provider than the fastest one. Their moving
+// average is only updated by the requests they serve, so without it a
+// provider that was slow once would never be measured again.
+const latencyProbeInterval = 20
+
+// latencySelector prefers the provider with the lowest moving average of
+// response times. Providers without samples yet are tried first so that they
+// get measured, and every latencyProbeInterval requests the slower providers
+// are tried first in turn to measure them again.
+type latencySelector struct {
+	next atomic.Uint64
+}
+
+func (s *latencySelector) Order(targets []*NodeProvider) []*NodeProvider {
+	latency := make(map[*NodeProvider]time.Duration, len(targets))
+	for _, target := range targets {
+		latency[target] = target.Latency()
+	}
+
+	ordered := append([]*NodeProvider(nil), targets...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return latency[ordered[i]] < latency[ordered[j]]
+	})
+
+	n := s.next.Add(1)
+	if len(ordered) > 1 && n%latencyProbeInterval == 0 {
+		// The fastest provider stays second, for failover.
+		probe := 1 + int((n/latencyProbeInterval-1)%uint64(len(ordered)-1))
+		copy(ordered[:probe+1], append([]*NodeProvider{ordered[probe]}, ordered[:probe]...))
+	}
+
+	return ordered
+}
+
+func rotate(targets []*NodeProvider, offset int) []*NodeProvider {
+	ordered := make([]*NodeProvider, 0, len(targets))
+	ordered = append(ordered, targets[offset:]...)
+
+	return append(ordered, targets[:offset]...)
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createNodeProviders(configs ...NodeProviderConfig) []*NodeProvider {
+	targets := make([]*NodeProvider, 0, len(configs))
+	for _, config := range configs {
+		targets = append(targets, &NodeProvider{Config: config})
+	}
+
+	return targets
+}
+
+func names(targets []*NodeProvider) []string {
+	result := make([]string, 0, len(targets))
+	for _, target := range targets {
+		result = append(result, target.Name())
+	}
+
+	return result
+}
+
+func TestNewSelector(t *testing.T) {
+	t.Parallel()
+
+	for _, strategy := range []string{
+		"",
+		StrategyFailover,
+		StrategyRoundRobin,
+		StrategyWeightedRandom,
+		StrategyLeastInFlight,
+		StrategyPriorityTiers,
+	} {
+		selector, err := NewSelector(strategy)
+		assert.NoError(t, err)
+		assert.NotNil(t, selector)
+	}
+
+	_, err := NewSelector("random")
+	assert.ErrorContains(t, err, "unknown proxy strategy")
+}
+
+func TestFailoverSelector(t *testing.T) {
+	t.Parallel()
+
+	targets := createNodeProviders(
+		NodeProviderConfig{Name: "A"},
+		NodeProviderConfig{Name: "B"},
+	)
+	selector, _ := NewSelector(StrategyFailover)
+
+	assert.Equal(t, []string{"A", "B"}, names(selector.Order(targets)))
+	assert.Equal(t, []string{"A", "B"}, names(selector.Order(targets)))
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	t.Parallel()
+
+	targets := createNodeProviders(
+		NodeProviderConfig{Name: "A"},
+		NodeProviderConfig{Name: "B"},
+		NodeProviderConfig{Name: "C"},
+	)
+	selector, _ := NewSelector(StrategyRoundRobin)
+
+	assert.Equal(t, []string{"A", "B", "C"}, names(selector.Order(targets)))
+	assert.Equal(t, []string{"B", "C", "A"}, names(selector.Order(targets)))
+	assert.Equal(t, []string{"C", "A", "B"}, names(selector.Order(targets)))
+	assert.Equal(t, []string{"A", "B", "C"}, names(selector.Order(targets)))
+	assert.Empty(t, selector.Order(nil))
+}
+
+func TestWeightedRandomSelector(t *testing.T) {
+	t.Parallel()
+
+	targets := createNodeProviders(
+		NodeProviderConfig{Name: "A", Weight: 9},
+		NodeProviderConfig{Name: "B", Weight: 1},
+	)
+	selector, _ := NewSelector(StrategyWeightedRandom)
+
+	first := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		ordered := selector.Order(targets)
+		assert.Len(t, ordered, 2)
+		first[ordered[0].Name()]++
+	}
+
+	assert.Greater(t, first["A"], 800)
+	assert.Greater(t, first["B"], 20)
+}
+
+func TestLeastInFlightSelector(t *testing.T) {
+	t.Parallel()
+
+	targets := createNodeProviders(
+		NodeProviderConfig{Name: "A"},
+		NodeProviderConfig{Name: "B"},
+		NodeProviderConfig{Name: "C"},
+	)
+	targets[0].inFlight.Store(3)
+	targets[1].inFlight.Store(1)
+	targets[2].inFlight.Store(1)
+
+	selector, _ := NewSelector(StrategyLeastInFlight)
+
+	assert.Equal(t, []string{"B", "C", "A"}, names(selector.Order(targets)))
+}
+
+func TestPriorityTiersSelector(t *testing.T) {
+	t.Parallel()
+
+	targets := createNodeProviders(
+		NodeProviderConfig{Name: "Backup", Tier: 1},
+		NodeProviderConfig{Name: "A", Tier: 0},
+		NodeProviderConfig{Name: "B", Tier: 0},
+	)
+	selector, _ := NewSelector(StrategyPriorityTiers)
+
+	assert.Equal(t, []string{"A", "B", "Backup"}, names(selector.Order(targets)))
+	assert.Equal(t, []string{"B", "A", "Backup"}, names(selector.Order(targets)))
+	assert.Equal(t, []string{"A", "B", "Backup"}, names(selector.Order(targets)))
+}
+
+func TestLatencySelector(t *testing.T) {
+	t.Parallel()
+
+	targets := createNodeProviders(
+		NodeProviderConfig{Name: "Slow"},
+		NodeProviderConfig{Name: "Fast"},
+		NodeProviderConfig{Name: "New"},
+	)
+	targets[0].latency.Observe(300*time.Millisecond, defaultLatencyAlpha)
+	targets[1].latency.Observe(50*time.Millisecond, defaultLatencyAlpha)
+
+	selector, _ := NewSelector(StrategyLatency)
+
+	assert.Equal(t, []string{"New", "Fast", "Slow"}, names(selector.Order(targets)))
+}
+
+func TestLatencySelectorProbes(t *testing.T) {
+	t.Parallel()
+
+	targets := createNodeProviders(
+		NodeProviderConfig{Name: "Slow"},
+		NodeProviderConfig{Name: "Slower"},
+		NodeProviderConfig{Name: "Fast"},
+	)
+	targets[0].latency.Observe(300*time.Millisecond, defaultLatencyAlpha)
+	targets[1].latency.Observe(500*time.Millisecond, defaultLatencyAlpha)
+	targets[2].latency.Observe(50*time.Millisecond, defaultLatencyAlpha)
+
+	selector, _ := NewSelector(StrategyLatency)
+
+	probes := []string{}
+	for i := 0; i < 4*latencyProbeInterval; i++ {
+		ordered := names(selector.Order(targets))
+		if ordered[0] != "Fast" {
+			assert.Equal(t, "Fast", ordered[1])
+			probes = append(probes, ordered[0])
+		}
+	}
+
+	// The slower providers are measured again in turn.
+	assert.Equal(t, []string{"Slow", "Slower", "Slow", "Slower"}, probes)
+}
+
+func TestEWMA(t *testing.T) {
+	t.Parallel()
+
+	var e ewma
+	assert.Zero(t, e.Value())
+
+	e.Observe(100*time.Millisecond, 0.5)
+	assert.Equal(t, 100*time.Millisecond, e.Value())
+
+	e.Observe(300*time.Millisecond, 0.5)
+	assert.Equal(t, 200*time.Millisecond, e.Value())
+}
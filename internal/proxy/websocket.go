@@ -0,0 +1,764 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+const (
+	wsDialTimeout         = 10 * time.Second
+	wsReconnectAttempts   = 10
+	wsReconnectDelay      = time.Second
+	wsPingInterval        = 30 * time.Second
+	wsReadLimit           = 32 * 1024 * 1024
+	wsResubscribeIDPrefix = "rpc-gateway-resubscribe-"
+)
+
+var wsUpgrader = websocket.Upgrader{ // nolint:gochecknoglobals
+	// RPC endpoints are called by dapps served from any origin.
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+var (
+	errNoWebSocketTarget = errors.New("no healthy target with a websocket connection")
+	errWebSocketClosed   = errors.New("websocket session closed")
+)
+
+type wsCallKind int
+
+const (
+	wsCallOther wsCallKind = iota
+	wsCallSubscribe
+	wsCallUnsubscribe
+	wsCallResubscribe
+	wsCallBatch
+)
+
+// wsCall is a request sent upstream that is still waiting for its response.
+type wsCall struct {
+	kind   wsCallKind
+	id     json.RawMessage
+	params json.RawMessage
+	// client facing ID of the subscription, for unsubscribe and resubscribe.
+	subscriptionID string
+	// done records the usage of the client message once it is answered.
+	done func(response []byte)
+}
+
+type wsSubscription struct {
+	params     json.RawMessage
+	upstreamID string
+}
+
+// wsMessage is any JSON-RPC message sent over a websocket: a request, a
+// response or a subscription notification.
+type wsMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpc.Error  `json:"error,omitempty"`
+}
+
+// wsSession proxies a client websocket to a healthy provider. It keeps track
+// of the client subscriptions so they can be recreated on another provider
+// when the upstream connection drops. The client keeps seeing the
+// subscription IDs it was given in the first place.
+type wsSession struct {
+	proxy    *Proxy
+	client   *websocket.Conn
+	clientMu sync.Mutex
+
+	// upstreamMu serializes writes to the upstream and is held while
+	// reconnecting, so that client messages wait for the new connection.
+	upstreamMu sync.Mutex
+
+	// reject applies the filters of the upgrade request to every call,
+	// charge its authentication to every message and record its usage
+	// tracking.
+	reject jsonrpc.RejectFunc
+	charge jsonrpc.ChargeFunc
+	record jsonrpc.RecordFunc
+
+	upstream       *websocket.Conn
+	target         *NodeProvider
+	connected      bool
+	calls          map[string]wsCall
+	subscriptions  map[string]*wsSubscription
+	upstreamIDs    map[string]string
+	resubscribeSeq int
+	closed         bool
+	done           chan struct{}
+	mu             sync.Mutex
+}
+
+// serveWebSocket upgrades the client connection and proxies it to a healthy
+// target with a websocket connection configured.
+func (p *Proxy) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	client, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error.
+		return
+	}
+	defer client.Close()
+	client.SetReadLimit(wsReadLimit)
+
+	// Calls are rejected by the filters of the upgrade request, then by
+	// the routes like the HTTP requests.
+	reject := jsonrpc.RejectFromContext(r.Context())
+	if p.router != nil {
+		filters := reject
+		reject = func(request jsonrpc.Request) *jsonrpc.Error {
+			if rpcErr := filters(request); rpcErr != nil {
+				return rpcErr
+			}
+
+			return p.router.Reject(request)
+		}
+	}
+
+	s := &wsSession{
+		proxy:         p,
+		client:        client,
+		reject:        reject,
+		charge:        jsonrpc.ChargeFromContext(r.Context()),
+		record:        jsonrpc.RecordFromContext(r.Context()),
+		calls:         make(map[string]wsCall),
+		subscriptions: make(map[string]*wsSubscription),
+		upstreamIDs:   make(map[string]string),
+		done:          make(chan struct{}),
+	}
+
+	if err := s.dial(nil); err != nil {
+		s.closeClient(websocket.CloseTryAgainLater, err.Error())
+
+		return
+	}
+
+	p.metricWebSocketConnections.Inc()
+	defer p.metricWebSocketConnections.Dec()
+
+	go s.readUpstream()
+	go s.keepAlive()
+
+	s.readClient()
+	s.close()
+}
+
+// dial connects to the first healthy target with a websocket connection
+// serving the routes of the session, trying the excluded target last. Since
+// a session stays on its target, targets serving every route are preferred.
+func (s *wsSession) dial(exclude *NodeProvider) error {
+	var preferred, candidates, last []*NodeProvider
+	for _, target := range s.proxy.healthyTargets(s.routes()) {
+		switch {
+		case target.Config.Connection.WS.URL == "":
+			continue
+		case target == exclude:
+			last = append(last, target)
+		case s.proxy.router.ServesAll(target):
+			preferred = append(preferred, target)
+		default:
+			candidates = append(candidates, target)
+		}
+	}
+
+	err := errNoWebSocketTarget
+	for _, target := range append(append(preferred, candidates...), last...) {
+		// The connection counts as a request against the limits.
+		if !s.proxy.acquire(target, 1) {
+			continue
+		}
+
+		// The configuration was validated when the target was created.
+		header, _ := target.Config.Connection.HTTP.Header()
+
+		ctx, cancel := context.WithTimeout(context.Background(), wsDialTimeout)
+		upstream, resp, dialErr := websocket.DefaultDialer.DialContext(ctx, target.Config.Connection.WS.URL, header)
+		cancel()
+		if resp != nil && resp.Body != nil {
+			resp.Body.Close()
+		}
+		target.breaker.Record(dialErr != nil)
+		if dialErr != nil {
+			s.proxy.metricRequestErrors.WithLabelValues(target.Name(), "websocket_dial").Inc()
+			err = fmt.Errorf("cannot connect to %s: %w", target.Name(), dialErr)
+
+			continue
+		}
+		upstream.SetReadLimit(wsReadLimit)
+
+		s.mu.Lock()
+		if s.closed {
+			// The client went away while connecting.
+			s.mu.Unlock()
+			upstream.Close()
+
+			return errWebSocketClosed
+		}
+		// The session counts as a request in flight, so that draining the
+		// target waits for it.
+		target.inFlight.Add(1)
+		s.upstream = upstream
+		s.target = target
+		s.connected = true
+		s.mu.Unlock()
+
+		return nil
+	}
+
+	return err
+}
+
+// routes returns a context holding the routes the target of the session
+// must serve, which is the route of eth_subscribe while the client has
+// subscriptions to recreate.
+func (s *wsSession) routes() context.Context {
+	ctx := context.Background()
+	if s.proxy.router == nil {
+		return ctx
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.subscriptions) == 0 {
+		return ctx
+	}
+
+	return s.proxy.router.withRoutes(ctx, []jsonrpc.Request{{Method: "eth_subscribe"}})
+}
+
+func (s *wsSession) currentUpstream() (*websocket.Conn, *NodeProvider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.upstream, s.target
+}
+
+func (s *wsSession) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+func (s *wsSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.closeUpstream()
+
+	// Calls still waiting for their response are recorded without one.
+	for _, call := range s.calls {
+		if call.done != nil {
+			call.done(nil)
+		}
+	}
+	s.calls = make(map[string]wsCall)
+}
+
+// closeUpstream closes the upstream connection and stops counting it as in
+// flight on its target. It must be called with s.mu held.
+func (s *wsSession) closeUpstream() {
+	if !s.connected {
+		return
+	}
+
+	s.connected = false
+	s.upstream.Close()
+	s.target.inFlight.Add(-1)
+}
+
+func (s *wsSession) readClient() {
+	for {
+		_, message, err := s.client.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		done := s.record(message)
+
+		if response, rejected := s.rejectMessage(message); rejected {
+			if response != nil {
+				s.writeClient(response)
+			}
+			done(response)
+
+			continue
+		}
+
+		if rpcErr := s.charge(message); rpcErr != nil {
+			response := refuseMessage(message, rpcErr)
+			if response != nil {
+				s.writeClient(response)
+			}
+			done(response)
+
+			continue
+		}
+
+		if response, unrouted := s.routeMessage(message); unrouted {
+			if response != nil {
+				s.writeClient(response)
+			}
+			done(response)
+
+			continue
+		}
+
+		if response, limited := s.limitMessage(message); limited {
+			if response != nil {
+				s.writeClient(response)
+			}
+			done(response)
+
+			continue
+		}
+
+		message = s.handleClientMessage(message, done)
+
+		s.upstreamMu.Lock()
+		upstream, _ := s.currentUpstream()
+		// A failed write means the upstream is gone. The upstream reader
+		// reconnects and fails the pending calls, including this one.
+		upstream.WriteMessage(websocket.TextMessage, message) // nolint:errcheck
+		s.upstreamMu.Unlock()
+	}
+}
+
+// rejectMessage reports whether the message contains calls rejected by the
+// filters and returns the response to send instead, if any. A batch with a
+// rejected call is not forwarded at all.
+func (s *wsSession) rejectMessage(message []byte) ([]byte, bool) {
+	requests, isBatch, err := jsonrpc.ParseRequests(message)
+	if err != nil {
+		return nil, false
+	}
+
+	responses := make([]jsonrpc.Response, 0, len(requests))
+	rejected := false
+	for _, request := range requests {
+		rpcErr := s.reject(request)
+		if rpcErr == nil {
+			rpcErr = &jsonrpc.Error{
+				Code:    jsonrpc.CodeInvalidRequest,
+				Message: "batch contains calls that are not available",
+			}
+		} else {
+			rejected = true
+		}
+
+		if !request.IsNotification() {
+			responses = append(responses, jsonrpc.NewErrorResponse(request.ID, rpcErr.Code, rpcErr.Message))
+		}
+	}
+
+	if !rejected {
+		return nil, false
+	}
+
+	return encodeResponses(responses, isBatch), true
+}
+
+// routeMessage reports whether the target of the session does not serve the
+// routes of the message, and returns the response to send instead, if any.
+func (s *wsSession) routeMessage(message []byte) ([]byte, bool) {
+	if s.proxy.router == nil {
+		return nil, false
+	}
+
+	requests, _, err := jsonrpc.ParseRequests(message)
+	if err != nil {
+		return nil, false
+	}
+
+	_, target := s.currentUpstream()
+	if routedTo(s.proxy.router.withRoutes(context.Background(), requests), target) {
+		return nil, false
+	}
+
+	return refuseMessage(message, &jsonrpc.Error{Code: jsonrpc.CodeNoUpstream, Message: errNoUpstream.Error()}), true
+}
+
+// limitMessage charges the calls of the message against the limits of the
+// target, and reports whether they are exceeded. The response to send
+// instead is returned, if any.
+func (s *wsSession) limitMessage(message []byte) ([]byte, bool) {
+	_, target := s.currentUpstream()
+	if target.limits.Allow(callCount(message)) {
+		return nil, false
+	}
+
+	return refuseMessage(message, &jsonrpc.Error{Code: jsonrpc.CodeNoUpstream, Message: errNoUpstream.Error()}), true
+}
+
+// refuseMessage returns the response answering every call of the message with
+// rpcErr, or nil if there is nothing to answer.
+func refuseMessage(message []byte, rpcErr *jsonrpc.Error) []byte {
+	requests, isBatch, err := jsonrpc.ParseRequests(message)
+	if err != nil {
+		requests, isBatch = []jsonrpc.Request{{}}, false
+	}
+
+	responses := make([]jsonrpc.Response, 0, len(requests))
+	for _, request := range requests {
+		if err != nil || !request.IsNotification() {
+			responses = append(responses, jsonrpc.NewErrorResponse(request.ID, rpcErr.Code, rpcErr.Message))
+		}
+	}
+
+	return encodeResponses(responses, isBatch)
+}
+
+// encodeResponses encodes the responses as a batch or, if the request was not
+// one, as a single response. It returns nil if there is nothing to send.
+func encodeResponses(responses []jsonrpc.Response, isBatch bool) []byte {
+	if len(responses) == 0 {
+		return nil
+	}
+
+	var response any = responses
+	if !isBatch {
+		response = responses[0]
+	}
+
+	encoded, _ := json.Marshal(response)
+
+	return encoded
+}
+
+// handleClientMessage records the request so its response can be matched,
+// and translates the subscription ID of eth_unsubscribe to the upstream one.
+// done is called with the response once it arrives, or right away for
+// messages that get none.
+func (s *wsSession) handleClientMessage(message []byte, done func([]byte)) []byte {
+	requests, isBatch, err := jsonrpc.ParseRequests(message)
+	if err != nil || (!isBatch && requests[0].IsNotification()) {
+		// Notifications and invalid messages are forwarded as they are.
+		done(nil)
+
+		return message
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isBatch {
+		// The response of a batch is matched by its first call.
+		for _, request := range requests {
+			if !request.IsNotification() {
+				s.calls[string(request.ID)] = wsCall{kind: wsCallBatch, id: request.ID, done: done}
+
+				return message
+			}
+		}
+		done(nil)
+
+		return message
+	}
+
+	request := requests[0]
+	call := wsCall{kind: wsCallOther, id: request.ID, params: request.Params, done: done}
+
+	switch request.Method {
+	case "eth_subscribe":
+		call.kind = wsCallSubscribe
+	case "eth_unsubscribe":
+		var params []string
+		if err := json.Unmarshal(request.Params, &params); err != nil || len(params) != 1 {
+			break
+		}
+
+		subscription, ok := s.subscriptions[params[0]]
+		if !ok {
+			break
+		}
+
+		call.kind = wsCallUnsubscribe
+		call.subscriptionID = params[0]
+		request.Params, _ = json.Marshal([]string{subscription.upstreamID})
+		if translated, err := json.Marshal(request); err == nil {
+			message = translated
+		}
+	}
+
+	s.calls[string(request.ID)] = call
+
+	return message
+}
+
+func (s *wsSession) readUpstream() {
+	for {
+		upstream, _ := s.currentUpstream()
+
+		_, message, err := upstream.ReadMessage()
+		if err != nil {
+			if s.isClosed() {
+				return
+			}
+
+			if err := s.reconnect(); err != nil {
+				s.closeClient(websocket.CloseTryAgainLater, err.Error())
+				s.close()
+
+				return
+			}
+
+			continue
+		}
+
+		if message = s.handleUpstreamMessage(message); message != nil {
+			s.writeClient(message)
+		}
+	}
+}
+
+// handleUpstreamMessage keeps the subscriptions up to date and rewrites the
+// upstream subscription IDs to the ones known by the client. It returns nil
+// for messages that must not reach the client.
+func (s *wsSession) handleUpstreamMessage(message []byte) []byte {
+	var msg wsMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		s.handleUpstreamBatch(message)
+
+		return message
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg.Method == "eth_subscription" {
+		return s.rewriteNotification(msg, message)
+	}
+
+	call, ok := s.calls[string(msg.ID)]
+	if !ok {
+		return message
+	}
+	delete(s.calls, string(msg.ID))
+	if call.done != nil {
+		call.done(message)
+	}
+
+	var upstreamID string
+	if msg.Error == nil {
+		json.Unmarshal(msg.Result, &upstreamID) // nolint:errcheck
+	}
+
+	switch call.kind {
+	case wsCallOther:
+	case wsCallSubscribe:
+		if upstreamID != "" {
+			s.subscriptions[upstreamID] = &wsSubscription{params: call.params, upstreamID: upstreamID}
+			s.upstreamIDs[upstreamID] = upstreamID
+		}
+	case wsCallUnsubscribe:
+		if subscription, ok := s.subscriptions[call.subscriptionID]; ok {
+			delete(s.upstreamIDs, subscription.upstreamID)
+			delete(s.subscriptions, call.subscriptionID)
+		}
+	case wsCallResubscribe:
+		subscription, ok := s.subscriptions[call.subscriptionID]
+		if ok && upstreamID != "" {
+			// Late notifications of the previous upstream subscription are
+			// dropped from now on.
+			delete(s.upstreamIDs, subscription.upstreamID)
+			subscription.upstreamID = upstreamID
+			s.upstreamIDs[upstreamID] = call.subscriptionID
+		}
+
+		return nil
+	}
+
+	return message
+}
+
+// handleUpstreamBatch matches the response of a batch with the client
+// message, to record its usage.
+func (s *wsSession) handleUpstreamBatch(message []byte) {
+	responses, isBatch, err := jsonrpc.ParseResponses(message)
+	if err != nil || !isBatch {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, response := range responses {
+		call, ok := s.calls[string(response.ID)]
+		if !ok || call.kind != wsCallBatch {
+			continue
+		}
+
+		delete(s.calls, string(response.ID))
+		call.done(message)
+
+		return
+	}
+}
+
+// rewriteNotification replaces the upstream subscription ID with the client
+// facing one. It must be called with s.mu held.
+func (s *wsSession) rewriteNotification(msg wsMessage, message []byte) []byte {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return message
+	}
+
+	var upstreamID string
+	if err := json.Unmarshal(params["subscription"], &upstreamID); err != nil {
+		return message
+	}
+
+	clientID, ok := s.upstreamIDs[upstreamID]
+	if !ok {
+		// Stale notification of a subscription that no longer exists.
+		return nil
+	}
+	if clientID == upstreamID {
+		return message
+	}
+
+	params["subscription"], _ = json.Marshal(clientID)
+	msg.Params, _ = json.Marshal(params)
+	rewritten, err := json.Marshal(msg)
+	if err != nil {
+		return message
+	}
+
+	return rewritten
+}
+
+// reconnect replaces the dropped upstream connection, preferably with another
+// provider, and recreates the client subscriptions on it.
+func (s *wsSession) reconnect() error {
+	s.upstreamMu.Lock()
+	defer s.upstreamMu.Unlock()
+
+	s.mu.Lock()
+	previous := s.target
+	s.closeUpstream()
+	s.mu.Unlock()
+	s.failPendingCalls()
+
+	var err error
+	for attempt := 0; attempt < wsReconnectAttempts; attempt++ {
+		if s.isClosed() {
+			return nil
+		}
+		if err = s.dial(previous); err == nil {
+			break
+		}
+		if errors.Is(err, errWebSocketClosed) {
+			return nil
+		}
+
+		time.Sleep(wsReconnectDelay)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.proxy.metricWebSocketReconnects.WithLabelValues(previous.Name(), s.target.Name()).Inc()
+
+	for clientID, subscription := range s.subscriptions {
+		s.resubscribeSeq++
+		id, _ := json.Marshal(fmt.Sprintf("%s%d", wsResubscribeIDPrefix, s.resubscribeSeq))
+		s.calls[string(id)] = wsCall{kind: wsCallResubscribe, id: id, subscriptionID: clientID}
+
+		request, _ := json.Marshal(jsonrpc.Request{
+			JSONRPC: jsonrpc.Version,
+			ID:      id,
+			Method:  "eth_subscribe",
+			Params:  subscription.params,
+		})
+		if err := s.upstream.WriteMessage(websocket.TextMessage, request); err != nil {
+			// The reader notices the broken connection and tries again.
+			break
+		}
+	}
+
+	return nil
+}
+
+// failPendingCalls answers the client requests lost with the upstream
+// connection with an error, so the client does not wait for them forever.
+func (s *wsSession) failPendingCalls() {
+	s.mu.Lock()
+	calls := s.calls
+	s.calls = make(map[string]wsCall)
+	s.mu.Unlock()
+
+	for _, call := range calls {
+		switch call.kind {
+		case wsCallResubscribe:
+			continue
+		case wsCallBatch:
+			// Batches are only tracked for their usage.
+			call.done(nil)
+
+			continue
+		}
+
+		message, err := json.Marshal(jsonrpc.NewErrorResponse(call.id, jsonrpc.CodeInternalError, "upstream connection lost"))
+		if err == nil {
+			s.writeClient(message)
+		}
+		if call.done != nil {
+			call.done(message)
+		}
+	}
+}
+
+// keepAlive pings the upstream so idle connections are not dropped by the
+// provider.
+func (s *wsSession) keepAlive() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			upstream, _ := s.currentUpstream()
+			upstream.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsDialTimeout)) // nolint:errcheck
+		}
+	}
+}
+
+func (s *wsSession) writeClient(message []byte) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+
+	s.client.WriteMessage(websocket.TextMessage, message) // nolint:errcheck
+}
+
+func (s *wsSession) closeClient(code int, reason string) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+
+	s.client.WriteControl( // nolint:errcheck
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second),
+	)
+}
@@ -0,0 +1,475 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+)
+
+// fakeWebSocketProvider answers eth_subscribe with its own subscription ID
+// and sends one notification right after. Drop closes the open connections.
+type fakeWebSocketProvider struct {
+	server         *httptest.Server
+	subscriptionID string
+	conns          []*websocket.Conn
+	mu             sync.Mutex
+}
+
+func newFakeWebSocketProvider(t *testing.T, subscriptionID string) *fakeWebSocketProvider {
+	t.Helper()
+
+	provider := &fakeWebSocketProvider{subscriptionID: subscriptionID}
+	provider.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		provider.mu.Lock()
+		provider.conns = append(provider.conns, conn)
+		provider.mu.Unlock()
+
+		for {
+			var request jsonrpc.Request
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+
+			result, _ := json.Marshal(provider.subscriptionID)
+			if request.Method != "eth_subscribe" {
+				result = json.RawMessage(`true`)
+			}
+			conn.WriteJSON(jsonrpc.Response{JSONRPC: jsonrpc.Version, ID: request.ID, Result: result})
+
+			if request.Method == "eth_subscribe" {
+				conn.WriteJSON(wsMessage{
+					JSONRPC: jsonrpc.Version,
+					Method:  "eth_subscription",
+					Params:  json.RawMessage(`{"subscription":"` + provider.subscriptionID + `","result":{"number":"0x1"}}`),
+				})
+			}
+		}
+	}))
+
+	return provider
+}
+
+func (f *fakeWebSocketProvider) URL() string {
+	return "ws" + strings.TrimPrefix(f.server.URL, "http")
+}
+
+func (f *fakeWebSocketProvider) Connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.conns)
+}
+
+func (f *fakeWebSocketProvider) Drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func readNotificationSubscription(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+
+	var msg wsMessage
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "eth_subscription", msg.Method)
+
+	var params struct {
+		Subscription string `json:"subscription"`
+	}
+	assert.NoError(t, json.Unmarshal(msg.Params, &params))
+
+	return params.Subscription
+}
+
+func TestWebSocketResubscribeOnUpstreamDrop(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	providerA := newFakeWebSocketProvider(t, "0xaaaa")
+	defer providerA.server.Close()
+	providerB := newFakeWebSocketProvider(t, "0xbbbb")
+	defer providerB.server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) {
+		c.Targets[0].Connection.WS.URL = providerA.URL()
+		c.Targets[1].Connection.WS.URL = providerB.URL()
+	}, providerA.server.URL, providerB.server.URL)
+
+	gateway := httptest.NewServer(proxy)
+	defer gateway.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	assert.NoError(t, client.WriteJSON(jsonrpc.Request{
+		JSONRPC: jsonrpc.Version,
+		ID:      json.RawMessage(`1`),
+		Method:  "eth_subscribe",
+		Params:  json.RawMessage(`["newHeads"]`),
+	}))
+
+	var response jsonrpc.Response
+	assert.NoError(t, client.ReadJSON(&response))
+	assert.Equal(t, `"0xaaaa"`, string(response.Result))
+	assert.Equal(t, "0xaaaa", readNotificationSubscription(t, client))
+
+	providerA.Drop()
+
+	// The subscription is recreated on the second provider, but the client
+	// keeps receiving notifications under its original ID.
+	assert.Equal(t, "0xaaaa", readNotificationSubscription(t, client))
+
+	assert.NoError(t, client.WriteJSON(jsonrpc.Request{
+		JSONRPC: jsonrpc.Version,
+		ID:      json.RawMessage(`2`),
+		Method:  "eth_unsubscribe",
+		Params:  json.RawMessage(`["0xaaaa"]`),
+	}))
+	assert.NoError(t, client.ReadJSON(&response))
+	assert.Equal(t, `2`, string(response.ID))
+	assert.Equal(t, `true`, string(response.Result))
+}
+
+func TestWebSocketWithoutWebSocketTargets(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	proxy := createTestProxy(t, nil, "http://localhost")
+
+	gateway := httptest.NewServer(proxy)
+	defer gateway.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	_, _, err = client.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	assert.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, websocket.CloseTryAgainLater, closeErr.Code)
+}
+
+func TestWebSocketAppliesRequestFilters(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	provider := newFakeWebSocketProvider(t, "0xaaaa")
+	defer provider.server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) {
+		c.Targets[0].Connection.WS.URL = provider.URL()
+	}, provider.server.URL)
+
+	reject := func(request jsonrpc.Request) *jsonrpc.Error {
+		if request.Method == "admin_peers" {
+			return &jsonrpc.Error{Code: jsonrpc.CodeMethodNotFound, Message: "blocked"}
+		}
+
+		return nil
+	}
+	gateway := httptest.NewServer(jsonrpc.Filter(reject, proxy))
+	defer gateway.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	assert.NoError(t, client.WriteJSON(jsonrpc.Request{JSONRPC: jsonrpc.Version, ID: json.RawMessage(`1`), Method: "admin_peers"}))
+
+	var response jsonrpc.Response
+	assert.NoError(t, client.ReadJSON(&response))
+	assert.Equal(t, `1`, string(response.ID))
+	assert.Equal(t, jsonrpc.CodeMethodNotFound, response.Error.Code)
+
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(
+		`[{"jsonrpc":"2.0","id":2,"method":"eth_chainId"},{"jsonrpc":"2.0","id":3,"method":"admin_peers"}]`)))
+
+	var responses []jsonrpc.Response
+	assert.NoError(t, client.ReadJSON(&responses))
+	assert.Len(t, responses, 2)
+	assert.Equal(t, jsonrpc.CodeInvalidRequest, responses[0].Error.Code)
+	assert.Equal(t, jsonrpc.CodeMethodNotFound, responses[1].Error.Code)
+
+	assert.NoError(t, client.WriteJSON(jsonrpc.Request{JSONRPC: jsonrpc.Version, ID: json.RawMessage(`4`), Method: "eth_chainId"}))
+	assert.NoError(t, client.ReadJSON(&response))
+	assert.Equal(t, `4`, string(response.ID))
+	assert.Equal(t, `true`, string(response.Result))
+}
+
+func TestWebSocketSessionInFlight(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	provider := newFakeWebSocketProvider(t, "0xaaaa")
+	defer provider.server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) {
+		c.Targets[0].Connection.WS.URL = provider.URL()
+	}, provider.server.URL)
+
+	gateway := httptest.NewServer(proxy)
+	defer gateway.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	assert.NoError(t, err)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	assert.NoError(t, client.WriteJSON(jsonrpc.Request{JSONRPC: jsonrpc.Version, ID: json.RawMessage(`1`), Method: "eth_chainId"}))
+	var response jsonrpc.Response
+	assert.NoError(t, client.ReadJSON(&response))
+
+	assert.Equal(t, int64(1), proxy.Status()[0].InFlight)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, proxy.Drain(ctx, "A"), context.DeadlineExceeded)
+
+	client.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, proxy.Drain(ctx, "A"))
+}
+
+func TestWebSocketDialAfterClose(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	provider := newFakeWebSocketProvider(t, "0xaaaa")
+	defer provider.server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) {
+		c.Targets[0].Connection.WS.URL = provider.URL()
+	}, provider.server.URL)
+
+	// A reconnect finishing after the client went away must not keep the new
+	// upstream connection.
+	s := &wsSession{proxy: proxy, closed: true}
+	assert.ErrorIs(t, s.dial(nil), errWebSocketClosed)
+	assert.Nil(t, s.upstream)
+	assert.Equal(t, int64(0), proxy.Status()[0].InFlight)
+}
+
+func TestWebSocketProviderLimits(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	provider := newFakeWebSocketProvider(t, "0xaaaa")
+	defer provider.server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) {
+		c.Targets[0].Connection.WS.URL = provider.URL()
+		c.Targets[0].Limits.DailyBudget = 2
+	}, provider.server.URL)
+
+	gateway := httptest.NewServer(proxy)
+	defer gateway.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// The connection and the first call use up the budget.
+	var response jsonrpc.Response
+	assert.NoError(t, client.WriteJSON(jsonrpc.Request{JSONRPC: jsonrpc.Version, ID: json.RawMessage(`1`), Method: "eth_chainId"}))
+	assert.NoError(t, client.ReadJSON(&response))
+	assert.Nil(t, response.Error)
+
+	assert.NoError(t, client.WriteJSON(jsonrpc.Request{JSONRPC: jsonrpc.Version, ID: json.RawMessage(`2`), Method: "eth_chainId"}))
+	assert.NoError(t, client.ReadJSON(&response))
+	assert.Equal(t, `2`, string(response.ID))
+	assert.Equal(t, jsonrpc.CodeNoUpstream, response.Error.Code)
+}
+
+func TestWebSocketChargesMessages(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	provider := newFakeWebSocketProvider(t, "0xaaaa")
+	defer provider.server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) {
+		c.Targets[0].Connection.WS.URL = provider.URL()
+	}, provider.server.URL)
+
+	var charged atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.ServeHTTP(w, r.WithContext(jsonrpc.WithCharge(r.Context(), func([]byte) *jsonrpc.Error {
+			if charged.Add(1) > 1 {
+				return &jsonrpc.Error{Code: jsonrpc.CodeRateLimited, Message: "rate limit exceeded"}
+			}
+
+			return nil
+		})))
+	}))
+	defer gateway.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var response jsonrpc.Response
+	assert.NoError(t, client.WriteJSON(jsonrpc.Request{JSONRPC: jsonrpc.Version, ID: json.RawMessage(`1`), Method: "eth_chainId"}))
+	assert.NoError(t, client.ReadJSON(&response))
+	assert.Nil(t, response.Error)
+
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(
+		`[{"jsonrpc":"2.0","id":2,"method":"eth_chainId"},{"jsonrpc":"2.0","id":3,"method":"eth_chainId"}]`)))
+
+	var responses []jsonrpc.Response
+	assert.NoError(t, client.ReadJSON(&responses))
+	assert.Len(t, responses, 2)
+	for i, response := range responses {
+		assert.Equal(t, strconv.Itoa(i+2), string(response.ID))
+		assert.Equal(t, jsonrpc.CodeRateLimited, response.Error.Code)
+	}
+}
+
+func TestWebSocketRecordsMessages(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	provider := newFakeWebSocketProvider(t, "0xaaaa")
+	defer provider.server.Close()
+
+	proxy := createTestProxy(t, func(c *Config) {
+		c.Targets[0].Connection.WS.URL = provider.URL()
+	}, provider.server.URL)
+
+	recorded := make(chan [2]string, 10)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := jsonrpc.WithCharge(r.Context(), func(message []byte) *jsonrpc.Error {
+			if strings.Contains(string(message), "eth_call") {
+				return &jsonrpc.Error{Code: jsonrpc.CodeRateLimited, Message: "rate limit exceeded"}
+			}
+
+			return nil
+		})
+		ctx = jsonrpc.WithRecord(ctx, func(message []byte) func([]byte) {
+			return func(response []byte) {
+				recorded <- [2]string{string(message), string(response)}
+			}
+		})
+		proxy.ServeHTTP(w, r.WithContext(ctx))
+	}))
+	defer gateway.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var response jsonrpc.Response
+	served := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(served)))
+	assert.NoError(t, client.ReadJSON(&response))
+
+	record := <-recorded
+	assert.Equal(t, served, record[0])
+	assert.Contains(t, record[1], `"id":1`)
+	assert.Contains(t, record[1], `"result":true`)
+
+	refused := `{"jsonrpc":"2.0","id":2,"method":"eth_call"}`
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(refused)))
+	assert.NoError(t, client.ReadJSON(&response))
+
+	record = <-recorded
+	assert.Equal(t, refused, record[0])
+	assert.Contains(t, record[1], strconv.Itoa(jsonrpc.CodeRateLimited))
+
+	// Notifications get no response.
+	notification := `{"jsonrpc":"2.0","method":"eth_chainId"}`
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(notification)))
+	assert.Equal(t, [2]string{notification, ""}, <-recorded)
+}
+
+func TestWebSocketMethodRouting(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	full := newFakeWebSocketProvider(t, "0xaaaa")
+	defer full.server.Close()
+	archive := newFakeWebSocketProvider(t, "0xbbbb")
+	defer archive.server.Close()
+
+	call := func(proxy *Proxy, method string) jsonrpc.Response {
+		gateway := httptest.NewServer(proxy)
+		defer gateway.Close()
+
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+		assert.NoError(t, err)
+		defer client.Close()
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		var response jsonrpc.Response
+		assert.NoError(t, client.WriteJSON(jsonrpc.Request{JSONRPC: jsonrpc.Version, ID: json.RawMessage(`1`), Method: method}))
+		assert.NoError(t, client.ReadJSON(&response))
+
+		return response
+	}
+
+	configure := func(archiveWS string) func(*Config) {
+		return func(config *Config) {
+			config.Targets[0].Connection.WS.URL = full.URL()
+			config.Targets[1].Connection.WS.URL = archiveWS
+			config.Targets[1].Tags = []string{"archive"}
+			config.Proxy.Routes = []RouteConfig{
+				{Methods: []string{"debug_*"}, Tags: []string{"archive"}},
+				{Methods: []string{"trace_*"}, Tags: []string{"trace"}},
+			}
+		}
+	}
+
+	// Sessions go to the targets serving every route.
+	proxy := createTestProxy(t, configure(archive.URL()), full.server.URL, archive.server.URL)
+	assert.Nil(t, call(proxy, "debug_traceTransaction").Error)
+	assert.Equal(t, 1, archive.Connections())
+	assert.Equal(t, 0, full.Connections())
+
+	response := call(proxy, "trace_block")
+	assert.Equal(t, jsonrpc.CodeMethodNotFound, response.Error.Code)
+
+	// Calls the target of the session does not serve are not forwarded.
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	proxy = createTestProxy(t, configure(""), full.server.URL, archive.server.URL)
+	response = call(proxy, "debug_traceTransaction")
+	assert.Equal(t, jsonrpc.CodeNoUpstream, response.Error.Code)
+	assert.Nil(t, call(proxy, "eth_chainId").Error)
+}
+
+func TestWebSocketResubscribeForgetsUpstreamID(t *testing.T) {
+	s := &wsSession{
+		calls: map[string]wsCall{
+			`"resubscribe"`: {kind: wsCallResubscribe, id: json.RawMessage(`"resubscribe"`), subscriptionID: "0xaaaa"},
+		},
+		subscriptions: map[string]*wsSubscription{"0xaaaa": {upstreamID: "0xaaaa"}},
+		upstreamIDs:   map[string]string{"0xaaaa": "0xaaaa"},
+	}
+
+	assert.Nil(t, s.handleUpstreamMessage([]byte(`{"jsonrpc":"2.0","id":"resubscribe","result":"0xbbbb"}`)))
+	assert.Equal(t, map[string]string{"0xbbbb": "0xaaaa"}, s.upstreamIDs)
+
+	notification := func(subscription string) []byte {
+		return []byte(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"` + subscription + `","result":{}}}`)
+	}
+
+	// Late notifications of the previous upstream subscription are dropped.
+	assert.Nil(t, s.handleUpstreamMessage(notification("0xaaaa")))
+	assert.Contains(t, string(s.handleUpstreamMessage(notification("0xbbbb"))), `"subscription":"0xaaaa"`)
+}
@@ -2,6 +2,7 @@ package rpcgateway
 
 import (
 	"github.com/sygmaprotocol/rpc-gateway/internal/metrics"
+	"github.com/sygmaprotocol/rpc-gateway/internal/middleware"
 	"github.com/sygmaprotocol/rpc-gateway/internal/proxy"
 )
 
@@ -11,4 +12,6 @@ type RPCGatewayConfig struct { //nolint:revive
 	Proxy        proxy.ProxyConfig          `json:"proxy"`
 	HealthChecks proxy.HealthCheckConfig    `json:"healthChecks"`
 	Targets      []proxy.NodeProviderConfig `json:"targets"`
+	// Methods restricts the JSON-RPC methods clients may call.
+	Methods middleware.MethodFilterConfig `json:"methods"`
 }
@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"slices"
 
-	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sygmaprotocol/rpc-gateway/internal/metrics"
+	"github.com/sygmaprotocol/rpc-gateway/internal/middleware"
 
 	"github.com/carlmjohnson/flowmatic"
 	"github.com/go-chi/chi/v5"
@@ -15,9 +19,12 @@ import (
 )
 
 type RPCGateway struct {
-	config RPCGatewayConfig
-	proxy  *proxy.Proxy
-	hcm    *proxy.HealthCheckManager
+	config  RPCGatewayConfig
+	proxy   *proxy.Proxy
+	hcm     *proxy.HealthCheckManager
+	handler http.Handler
+
+	metricRejectedCalls *prometheus.CounterVec
 }
 
 func (r *RPCGateway) Start(c context.Context) error {
@@ -36,6 +43,64 @@ func (r *RPCGateway) Stop(c context.Context) error {
 	)
 }
 
+// Name returns the name of the gateway.
+func (r *RPCGateway) Name() string {
+	return r.config.Name
+}
+
+// Path returns the path the gateway is served on, without leading slash.
+func (r *RPCGateway) Path() string {
+	return r.config.Proxy.Path
+}
+
+// Proxy returns the proxy serving the gateway.
+func (r *RPCGateway) Proxy() *proxy.Proxy {
+	return r.proxy
+}
+
+// Config returns the configuration the gateway was built from.
+func (r *RPCGateway) Config() RPCGatewayConfig {
+	return r.config
+}
+
+// Register serves the gateway on its path of the router.
+func (r *RPCGateway) Register(router *chi.Mux) {
+	router.Handle(fmt.Sprintf("/%s", r.config.Proxy.Path), r.handler)
+}
+
+// Inherit carries the runtime state of the providers of previous, the
+// gateway this one replaces on reload, over to it. It must be called before
+// the gateway is started.
+func (r *RPCGateway) Inherit(previous *RPCGateway) {
+	r.proxy.Inherit(previous.proxy)
+	r.hcm.Inherit(previous.hcm)
+}
+
+// DeleteMetrics deletes the metric series of the providers next, the gateway
+// replacing this one on reload, does not have. All the metrics are
+// unregistered if next is nil, i.e. the gateway was removed. It must be called
+// once the gateway is stopped.
+func (r *RPCGateway) DeleteMetrics(next *RPCGateway) {
+	if next == nil {
+		r.proxy.UnregisterMetrics()
+		r.hcm.UnregisterMetrics()
+		if r.metricRejectedCalls != nil {
+			prometheus.DefaultRegisterer.Unregister(r.metricRejectedCalls)
+		}
+
+		return
+	}
+
+	for _, target := range r.config.Targets {
+		if !slices.ContainsFunc(next.config.Targets, func(kept proxy.NodeProviderConfig) bool {
+			return kept.Name == target.Name
+		}) {
+			r.proxy.DeleteMetrics(target.Name)
+			r.hcm.DeleteMetrics(target.Name)
+		}
+	}
+}
+
 func NewRPCGateway(config RPCGatewayConfig, router *chi.Mux) (*RPCGateway, error) {
 	logLevel := slog.LevelInfo
 	if os.Getenv("DEBUG") == "true" {
@@ -68,25 +133,27 @@ func NewRPCGateway(config RPCGatewayConfig, router *chi.Mux) (*RPCGateway, error
 		return nil, errors.Wrap(err, "proxy failed")
 	}
 
-	router.Handle(fmt.Sprintf("/%s", config.Proxy.Path), proxy)
-
-	return &RPCGateway{
-		config: config,
-		proxy:  proxy,
-		hcm:    hcm,
-	}, nil
-}
-
-// NewRPCGatewayFromConfigFile creates an instance of RPCGateway from provided
-// configuration file.
-func NewRPCGatewayFromConfigFile(configFile string, router *chi.Mux) (*RPCGateway, error) {
-	config, err := util.LoadJSONFile[RPCGatewayConfig](configFile)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to load config")
+	var handler http.Handler = proxy
+	var metricRejectedCalls *prometheus.CounterVec
+	if !config.Methods.IsEmpty() {
+		metricRejectedCalls = metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_rejected_calls_total_" + config.Name,
+				Help: "The total number of calls rejected by the method allowlist or denylist, by matching rule",
+			}, []string{
+				"rule",
+			}))
+		handler = middleware.MethodFilter(config.Methods, metricRejectedCalls)(handler)
 	}
 
-	fmt.Println("Starting RPC Gateway for " + config.Name + " on path: /" + config.Proxy.Path)
+	gateway := &RPCGateway{
+		config:              config,
+		proxy:               proxy,
+		hcm:                 hcm,
+		handler:             handler,
+		metricRejectedCalls: metricRejectedCalls,
+	}
+	gateway.Register(router)
 
-	// Pass the metrics router as an argument to NewRPCGateway.
-	return NewRPCGateway(*config, router)
+	return gateway, nil
 }
@@ -0,0 +1,91 @@
+package usage
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Report is the usage of every token over a time window.
+type Report struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Usage []Usage   `json:"usage"`
+}
+
+// Export returns the usage recorded between from and to, by token, gateway
+// and method. Usage is recorded by the hour, so hours partially within the
+// window are included entirely.
+func (r *Recorder) Export(from, to time.Time) Report {
+	from = from.UTC().Truncate(bucketSize)
+	to = to.UTC()
+
+	r.mu.Lock()
+	totals := make(map[usageKey]*Usage)
+	for key, usage := range r.buckets {
+		if key.bucket.Before(from) || !key.bucket.Before(to) {
+			continue
+		}
+
+		key.bucket = time.Time{}
+		total, ok := totals[key]
+		if !ok {
+			total = &Usage{Token: usage.Token, Gateway: usage.Gateway, Method: usage.Method}
+			totals[key] = total
+		}
+		total.Requests += usage.Requests
+		total.Errors += usage.Errors
+		total.RateLimited += usage.RateLimited
+		total.RequestBytes += usage.RequestBytes
+		total.ResponseBytes += usage.ResponseBytes
+	}
+	r.mu.Unlock()
+
+	report := Report{From: from, To: to, Usage: make([]Usage, 0, len(totals))}
+	for _, total := range totals {
+		report.Usage = append(report.Usage, *total)
+	}
+	sort.Slice(report.Usage, func(i, j int) bool {
+		a, b := report.Usage[i], report.Usage[j]
+		if a.Token != b.Token {
+			return a.Token < b.Token
+		}
+		if a.Gateway != b.Gateway {
+			return a.Gateway < b.Gateway
+		}
+
+		return a.Method < b.Method
+	})
+
+	return report
+}
+
+func (r Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{
+		"token", "gateway", "method", "requests", "errors", "rate_limited", "request_bytes", "response_bytes",
+	}); err != nil {
+		return err
+	}
+
+	for _, usage := range r.Usage {
+		if err := writer.Write([]string{
+			usage.Token,
+			usage.Gateway,
+			usage.Method,
+			strconv.FormatUint(usage.Requests, 10),
+			strconv.FormatUint(usage.Errors, 10),
+			strconv.FormatUint(usage.RateLimited, 10),
+			strconv.FormatUint(usage.RequestBytes, 10),
+			strconv.FormatUint(usage.ResponseBytes, 10),
+		}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
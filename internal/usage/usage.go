@@ -0,0 +1,382 @@
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+	"github.com/sygmaprotocol/rpc-gateway/internal/metrics"
+	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+)
+
+const (
+	defaultRetention = time.Hour * 24 * 31
+	bucketSize       = time.Hour
+
+	// methodBatch labels the bytes of batches, which cannot be attributed to
+	// a single method.
+	methodBatch = "batch"
+	// labelOther labels the requests that did not reach a gateway and the
+	// calls that were not served, e.g. rejected or to unknown methods, as
+	// well as requests that are not JSON-RPC. Their paths and methods come
+	// from clients and would otherwise create unbounded series.
+	labelOther = "other"
+
+	// maxCapturedResponse is the size of the responses inspected for
+	// JSON-RPC errors. Errors of larger responses are not counted.
+	maxCapturedResponse = 1 << 20
+)
+
+// Errors of calls that were not served, whose method is not counted.
+var unservedCodes = map[int]bool{ // nolint:gochecknoglobals
+	jsonrpc.CodeParseError:     true,
+	jsonrpc.CodeInvalidRequest: true,
+	jsonrpc.CodeMethodNotFound: true,
+	jsonrpc.CodeForbidden:      true,
+}
+
+type Config struct {
+	// Retention is how long usage is kept for export. Defaults to 31 days.
+	Retention util.DurationUnmarshalled `json:"retention"`
+}
+
+// Usage is the usage of a token on a gateway for a method.
+type Usage struct {
+	Token         string `json:"token"`
+	Gateway       string `json:"gateway"`
+	Method        string `json:"method"`
+	Requests      uint64 `json:"requests"`
+	Errors        uint64 `json:"errors"`
+	RateLimited   uint64 `json:"rateLimited"`
+	RequestBytes  uint64 `json:"requestBytes"`
+	ResponseBytes uint64 `json:"responseBytes"`
+}
+
+type usageKey struct {
+	bucket  time.Time
+	token   string
+	gateway string
+	method  string
+}
+
+// Recorder records the usage of tokens, both as Prometheus counters and in
+// hourly buckets kept for export.
+type Recorder struct {
+	retention time.Duration
+	buckets   map[usageKey]*Usage
+	evicted   time.Time
+	mu        sync.Mutex
+	now       func() time.Time
+
+	metricRequests      *prometheus.CounterVec
+	metricErrors        *prometheus.CounterVec
+	metricRateLimited   *prometheus.CounterVec
+	metricRequestBytes  *prometheus.CounterVec
+	metricResponseBytes *prometheus.CounterVec
+}
+
+func NewRecorder(config Config) *Recorder {
+	retention := time.Duration(config.Retention)
+	if retention <= 0 {
+		retention = defaultRetention
+	}
+
+	labels := []string{"token", "gateway", "method"}
+
+	return &Recorder{
+		retention: retention,
+		buckets:   make(map[usageKey]*Usage),
+		now:       time.Now,
+		metricRequests: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_token_requests_total",
+				Help: "The total number of calls made with a given token",
+			}, labels)),
+		metricErrors: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_token_errors_total",
+				Help: "The total number of calls made with a given token that got an error",
+			}, labels)),
+		metricRateLimited: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_token_rate_limited_total",
+				Help: "The total number of calls made with a given token that were rejected by its rate limit or quotas",
+			}, labels)),
+		metricRequestBytes: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_token_request_bytes_total",
+				Help: "The total size of the requests made with a given token",
+			}, labels)),
+		metricResponseBytes: metrics.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "zeroex_rpc_gateway_token_response_bytes_total",
+				Help: "The total size of the responses to requests made with a given token",
+			}, labels)),
+	}
+}
+
+// Track returns a response writer recording the usage of the request made
+// with the token once done is called, after the request is served. Calls are
+// rate limited when the response status is 429 and errors when it is another
+// error status or their JSON-RPC response is an error. The gateway is the
+// route the request was served by, so Track must be called by a middleware
+// of the router.
+func (r *Recorder) Track(w http.ResponseWriter, req *http.Request, token string, body []byte) (http.ResponseWriter, func()) {
+	tw := &trackingWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+	return tw, func() {
+		r.record(token, gatewayLabel(req), body, tw.statusCode, tw.responses(), tw.written)
+	}
+}
+
+// TrackMessage returns the function recording the usage of the messages
+// received outside of the body of the request made with the token, e.g. over
+// a websocket. Calls are rate limited when their responses are all errors of
+// the gateway's rate limit or quotas, and errors when their response is any
+// other error.
+func (r *Recorder) TrackMessage(req *http.Request, token string) jsonrpc.RecordFunc {
+	return func(message []byte) func([]byte) {
+		return func(response []byte) {
+			responses := parseResponses(response)
+
+			statusCode := http.StatusOK
+			if rateLimited(responses) {
+				statusCode = http.StatusTooManyRequests
+			}
+
+			r.record(token, gatewayLabel(req), message, statusCode, responses, len(response))
+		}
+	}
+}
+
+func (r *Recorder) record(token, gateway string, body []byte, statusCode int, responses map[string]*jsonrpc.Error, written int) {
+	requests, isBatch, err := jsonrpc.ParseRequests(body)
+	if err != nil || len(requests) == 0 {
+		requests, isBatch = []jsonrpc.Request{{}}, false
+	}
+
+	methods := make([]string, len(requests))
+	for i, request := range requests {
+		methods[i] = methodLabel(request, statusCode, responses, isBatch)
+	}
+
+	bytesMethod := methods[0]
+	if isBatch {
+		bytesMethod = methodBatch
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucket := r.now().UTC().Truncate(bucketSize)
+	r.evict(bucket)
+
+	for i, request := range requests {
+		method := methods[i]
+		usage := r.usage(bucket, token, gateway, method)
+
+		switch {
+		case statusCode == http.StatusTooManyRequests:
+			usage.RateLimited++
+			r.metricRateLimited.WithLabelValues(token, gateway, method).Inc()
+		case statusCode >= http.StatusBadRequest || responses[string(request.ID)] != nil:
+			usage.Requests++
+			usage.Errors++
+			r.metricRequests.WithLabelValues(token, gateway, method).Inc()
+			r.metricErrors.WithLabelValues(token, gateway, method).Inc()
+		default:
+			usage.Requests++
+			r.metricRequests.WithLabelValues(token, gateway, method).Inc()
+		}
+	}
+
+	usage := r.usage(bucket, token, gateway, bytesMethod)
+	usage.RequestBytes += uint64(len(body))
+	usage.ResponseBytes += uint64(written)
+	r.metricRequestBytes.WithLabelValues(token, gateway, bytesMethod).Add(float64(len(body)))
+	r.metricResponseBytes.WithLabelValues(token, gateway, bytesMethod).Add(float64(written))
+}
+
+// gatewayLabel returns the path of the gateway the request was routed to, or
+// labelOther if it did not reach one.
+func gatewayLabel(req *http.Request) string {
+	rctx := chi.RouteContext(req.Context())
+	if rctx == nil {
+		return labelOther
+	}
+
+	// Unmatched paths only leave the wildcard of the mount point.
+	gateway := strings.Trim(rctx.RoutePattern(), "/")
+	if gateway == "" || strings.Contains(gateway, "*") {
+		return labelOther
+	}
+
+	return gateway
+}
+
+// methodLabel returns the method of the call if it was served, or
+// labelOther. Calls rejected by the gateway, notifications and calls the
+// providers answered with an error meaning the method does not exist are not
+// counted by method, since clients could otherwise make up any number of
+// method names. The response of a single call may be too large to be
+// inspected, which only happens for methods that exist.
+func methodLabel(request jsonrpc.Request, statusCode int, responses map[string]*jsonrpc.Error, isBatch bool) string {
+	if statusCode >= http.StatusBadRequest || request.IsNotification() {
+		return labelOther
+	}
+
+	if responses == nil {
+		if isBatch {
+			return labelOther
+		}
+
+		return request.Method
+	}
+
+	rpcErr, ok := responses[string(request.ID)]
+	if !ok || (rpcErr != nil && unservedCodes[rpcErr.Code]) {
+		return labelOther
+	}
+
+	return request.Method
+}
+
+// usage returns the usage in the bucket. The lock must be held.
+func (r *Recorder) usage(bucket time.Time, token, gateway, method string) *Usage {
+	key := usageKey{bucket: bucket, token: token, gateway: gateway, method: method}
+
+	usage, ok := r.buckets[key]
+	if !ok {
+		usage = &Usage{Token: token, Gateway: gateway, Method: method}
+		r.buckets[key] = usage
+	}
+
+	return usage
+}
+
+// evict removes the buckets older than the retention, once per bucket. The
+// lock must be held.
+func (r *Recorder) evict(current time.Time) {
+	if current.Equal(r.evicted) {
+		return
+	}
+	r.evicted = current
+
+	for key := range r.buckets {
+		if current.Sub(key.bucket) > r.retention {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// trackingWriter counts the bytes written and keeps the beginning of the
+// response to find JSON-RPC errors in it.
+type trackingWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	written     int
+	captured    bytes.Buffer
+}
+
+func (w *trackingWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.written += n
+	if w.captured.Len()+n <= maxCapturedResponse {
+		w.captured.Write(b[:n])
+	}
+
+	return n, err
+}
+
+func (w *trackingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets websocket upgrades through.
+func (w *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	return hijacker.Hijack()
+}
+
+// responses returns the JSON-RPC responses by ID, mapped to their error or
+// to nil if they succeeded. It returns nil if the response could not be
+// inspected.
+func (w *trackingWriter) responses() map[string]*jsonrpc.Error {
+	if w.written == 0 || w.captured.Len() != w.written {
+		return nil
+	}
+
+	body := w.captured.Bytes()
+	if w.Header().Get(headers.ContentEncoding) == "gzip" {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil
+		}
+		defer reader.Close()
+
+		body, err = io.ReadAll(io.LimitReader(reader, maxCapturedResponse))
+		if err != nil {
+			return nil
+		}
+	}
+
+	return parseResponses(body)
+}
+
+// parseResponses returns the JSON-RPC responses of body by ID, mapped to
+// their error or to nil if they succeeded. It returns nil if body holds none.
+func parseResponses(body []byte) map[string]*jsonrpc.Error {
+	responses, _, err := jsonrpc.ParseResponses(body)
+	if err != nil {
+		return nil
+	}
+
+	byID := make(map[string]*jsonrpc.Error, len(responses))
+	for _, response := range responses {
+		byID[string(response.ID)] = response.Error
+	}
+
+	return byID
+}
+
+// rateLimited reports whether all the responses are errors of the gateway's
+// rate limit or quotas.
+func rateLimited(responses map[string]*jsonrpc.Error) bool {
+	if len(responses) == 0 {
+		return false
+	}
+
+	for _, rpcErr := range responses {
+		if rpcErr == nil || (rpcErr.Code != jsonrpc.CodeRateLimited && rpcErr.Code != jsonrpc.CodeQuotaExceeded) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,159 @@
+package usage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// serve routes the request like the gateway does, with the gateways mounted
+// on the root router that tracks the usage.
+func serve(recorder *Recorder, token, path, body string, handler http.HandlerFunc) {
+	gateways := chi.NewRouter()
+	gateways.Handle("/mainnet", handler)
+	gateways.Handle("/sepolia", handler)
+
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w, done := recorder.Track(w, r, token, []byte(body))
+			defer done()
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.Mount("/", gateways)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+}
+
+func respond(statusCode int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write([]byte(body)) // nolint:errcheck
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	recorder := NewRecorder(Config{})
+	now := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return now }
+
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_call"}]`
+	batchResponse := `[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"error":{"code":3,"message":"execution reverted"}}]`
+	serve(recorder, "Partner", "/mainnet", batch, respond(http.StatusOK, batchResponse))
+
+	// Gzipped responses are inspected too.
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	writer.Write([]byte(`{"jsonrpc":"2.0","id":3,"error":{"code":-32000,"message":"failed"}}`)) // nolint:errcheck
+	writer.Close()
+	serve(recorder, "Partner", "/mainnet", `{"jsonrpc":"2.0","id":3,"method":"eth_call"}`,
+		func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipped.Bytes()) // nolint:errcheck
+		})
+
+	now = now.Add(time.Hour)
+	rateLimited := `{"jsonrpc":"2.0","id":4,"error":{"code":-32005,"message":"rate limit exceeded"}}`
+	serve(recorder, "Partner", "/mainnet", `{"jsonrpc":"2.0","id":4,"method":"eth_call"}`,
+		respond(http.StatusTooManyRequests, rateLimited))
+	serve(recorder, "Internal", "/sepolia", `{"jsonrpc":"2.0","id":5,"method":"eth_chainId"}`,
+		respond(http.StatusOK, `{"jsonrpc":"2.0","id":5,"result":"0xaa36a7"}`))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(recorder.metricRequests.WithLabelValues("Partner", "mainnet", "eth_call")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(recorder.metricErrors.WithLabelValues("Partner", "mainnet", "eth_call")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(recorder.metricRateLimited.WithLabelValues("Partner", "mainnet", "other")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(recorder.metricErrors.WithLabelValues("Partner", "mainnet", "eth_chainId")))
+	assert.Equal(t, float64(len(batch)), testutil.ToFloat64(recorder.metricRequestBytes.WithLabelValues("Partner", "mainnet", "batch")))
+
+	report := recorder.Export(now.Add(-2*time.Hour), now.Add(time.Hour))
+	assert.Equal(t, []Usage{
+		{Token: "Internal", Gateway: "sepolia", Method: "eth_chainId", Requests: 1, RequestBytes: 47, ResponseBytes: 44},
+		{Token: "Partner", Gateway: "mainnet", Method: "batch", RequestBytes: uint64(len(batch)), ResponseBytes: uint64(len(batchResponse))},
+		{Token: "Partner", Gateway: "mainnet", Method: "eth_call", Requests: 2, Errors: 2, RequestBytes: 44,
+			ResponseBytes: uint64(gzipped.Len())},
+		{Token: "Partner", Gateway: "mainnet", Method: "eth_chainId", Requests: 1},
+		{Token: "Partner", Gateway: "mainnet", Method: "other", RateLimited: 1, RequestBytes: 44, ResponseBytes: uint64(len(rateLimited))},
+	}, report.Usage)
+
+	// Only the hours within the window are exported.
+	report = recorder.Export(now, now.Add(time.Hour))
+	assert.Len(t, report.Usage, 2)
+
+	var csv bytes.Buffer
+	assert.NoError(t, report.WriteCSV(&csv))
+	assert.Equal(t, "token,gateway,method,requests,errors,rate_limited,request_bytes,response_bytes\n"+
+		"Internal,sepolia,eth_chainId,1,0,0,47,44\n"+
+		"Partner,mainnet,other,0,0,1,44,80\n", csv.String())
+
+	// Usage older than the retention is dropped.
+	now = now.Add(defaultRetention + 2*time.Hour)
+	serve(recorder, "Internal", "/sepolia", `{"jsonrpc":"2.0","id":6,"method":"eth_chainId"}`, respond(http.StatusOK, `{}`))
+	assert.Len(t, recorder.Export(time.Time{}, now.Add(time.Hour)).Usage, 1)
+}
+
+func TestRecorderTrackMessage(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	recorder := NewRecorder(Config{})
+	now := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return now }
+
+	var record func([]byte) func([]byte)
+	serve(recorder, "Partner", "/mainnet", "", func(_ http.ResponseWriter, r *http.Request) {
+		record = recorder.TrackMessage(r, "Partner")
+	})
+
+	call := `{"jsonrpc":"2.0","id":1,"method":"eth_call"}`
+	response := `{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"execution reverted"}}`
+	record([]byte(call))([]byte(response))
+
+	rateLimited := `{"jsonrpc":"2.0","id":2,"error":{"code":-32005,"message":"rate limit exceeded"}}`
+	record([]byte(`{"jsonrpc":"2.0","id":2,"method":"eth_call"}`))([]byte(rateLimited))
+
+	// Calls without a response are counted by method.
+	record([]byte(`{"jsonrpc":"2.0","id":3,"method":"eth_chainId"}`))(nil)
+
+	assert.Equal(t, []Usage{
+		{Token: "Partner", Gateway: "mainnet", Method: "eth_call", Requests: 1, Errors: 1,
+			RequestBytes: uint64(len(call)), ResponseBytes: uint64(len(response))},
+		{Token: "Partner", Gateway: "mainnet", Method: "eth_chainId", Requests: 1, RequestBytes: 47},
+		// The request opening the websocket is counted too.
+		{Token: "Partner", Gateway: "mainnet", Method: "other", Requests: 1, RateLimited: 1, RequestBytes: 44,
+			ResponseBytes: uint64(len(rateLimited))},
+	}, recorder.Export(now, now.Add(time.Hour)).Usage)
+}
+
+func TestRecorderLabels(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	recorder := NewRecorder(Config{})
+
+	// Paths that are not gateways and methods the providers do not know are
+	// not counted separately.
+	serve(recorder, "Partner", "/random", `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`,
+		respond(http.StatusOK, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	serve(recorder, "Partner", "/mainnet", `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"made_up"}]`,
+		respond(http.StatusOK, `[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"method not found"}}]`))
+	serve(recorder, "Partner", "/mainnet", `{"jsonrpc":"2.0","method":"made_up_notification"}`, respond(http.StatusOK, ``))
+	serve(recorder, "Partner", "/mainnet", `{"jsonrpc":"2.0","id":3,"method":"admin_peers"}`,
+		respond(http.StatusForbidden, `{"jsonrpc":"2.0","id":3,"error":{"code":-32041,"message":"forbidden"}}`))
+
+	report := recorder.Export(time.Time{}, time.Now().Add(time.Hour))
+	methods := make([]string, 0, len(report.Usage))
+	for _, usage := range report.Usage {
+		methods = append(methods, usage.Gateway+" "+usage.Method)
+	}
+	assert.Equal(t, []string{"mainnet batch", "mainnet eth_chainId", "mainnet other", "other other"}, methods)
+	assert.Equal(t, 3.0, testutil.ToFloat64(recorder.metricRequests.WithLabelValues("Partner", "mainnet", "other")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(recorder.metricErrors.WithLabelValues("Partner", "mainnet", "other")))
+}
@@ -17,12 +17,13 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httplog/v2"
 
+	"github.com/sygmaprotocol/rpc-gateway/internal/admin"
 	"github.com/sygmaprotocol/rpc-gateway/internal/auth"
 	"github.com/sygmaprotocol/rpc-gateway/internal/metrics"
+	"github.com/sygmaprotocol/rpc-gateway/internal/usage"
 	"github.com/sygmaprotocol/rpc-gateway/internal/util"
 
 	"github.com/pkg/errors"
-	"github.com/sygmaprotocol/rpc-gateway/internal/rpcgateway"
 	"github.com/urfave/cli/v2"
 )
 
@@ -30,6 +31,9 @@ import (
 // including metrics and gateway configurations.
 type Config struct {
 	Metrics  MetricsConfig   `json:"metrics"`
+	Admin    AdminConfig     `json:"admin"`
+	Auth     auth.Config     `json:"auth"`
+	Usage    usage.Config    `json:"usage"`
 	Port     uint            `json:"port"`
 	Gateways []GatewayConfig `json:"gateways"`
 }
@@ -38,6 +42,12 @@ type MetricsConfig struct {
 	Port uint `json:"port"`
 }
 
+// AdminConfig enables the admin API when Port is set. The API token is read
+// from the ADMIN_TOKEN environment variable.
+type AdminConfig struct {
+	Port uint `json:"port"`
+}
+
 type GatewayConfig struct {
 	ConfigFile string `json:"configFile"`
 	Name       string `json:"name"`
@@ -68,6 +78,15 @@ func main() {
 				Usage: "Enable basic authentication.",
 				Value: false,
 			},
+			&cli.StringFlag{
+				Name:  "token-file",
+				Usage: "The JSON or YAML file with hashed tokens used for authentication instead of GATEWAY_TOKEN_MAP.",
+			},
+			&cli.DurationFlag{
+				Name:  "reload-interval",
+				Usage: "How often configuration files are checked for changes. Zero disables it, SIGHUP always reloads.",
+				Value: time.Second * 10,
+			},
 		},
 		Action: func(cc *cli.Context) error {
 			configPath := resolveConfigPath(cc.String("config"), cc.Bool("env"))
@@ -76,34 +95,43 @@ func main() {
 				return errors.Wrap(err, "failed to load config")
 			}
 
-			logger := configureLogger()
+			logger := configureLogger(config.Auth)
 			startMetricsServer(config.Metrics.Port)
 
-			r := chi.NewRouter()
-			r.Use(httplog.RequestLogger(logger))
-			r.Use(middleware.Recoverer)
-			r.Use(middleware.Heartbeat("/health"))
+			var extractKey func(http.Handler) http.Handler
+			if cc.Bool("auth") {
+				extractKey, err = auth.ExtractKey(config.Auth)
+				if err != nil {
+					return errors.Wrap(err, "invalid auth config")
+				}
+			}
+			r := newRouter(logger, extractKey)
 			// Add basic auth middleware
+			var recorder *usage.Recorder
 			if cc.Bool("auth") {
-				tokenMapJSON := os.Getenv("GATEWAY_TOKEN_MAP")
-				if tokenMapJSON == "" {
-					return errors.New("GATEWAY_TOKEN_MAP environment variable must be set for authentication")
+				store, err := loadTokenStore(c, cc.String("token-file"), cc.Duration("reload-interval"))
+				if err != nil {
+					return err
 				}
 
-				var tokenMap map[string]auth.TokenInfo
+				recorder = usage.NewRecorder(config.Usage)
+				r.Use(auth.StoreAuth(store, config.Auth.Costs, recorder))
+				fmt.Println("Authentication configured on gateway")
+			}
 
-				if err := json.Unmarshal([]byte(tokenMapJSON), &tokenMap); err != nil {
-					return errors.Wrap(err, "failed to parse GATEWAY_TOKEN_MAP")
-				}
+			gateways := newGatewayManager(configPath, config)
+			if err := gateways.Load(c); err != nil {
+				return err
+			}
+			r.Mount("/", gateways)
 
-				for _, details := range tokenMap {
-					if details.NumOfRequestPerSec <= 0 {
-						return errors.New("numOfRequestPerSec must be a positive number")
-					}
+			if config.Admin.Port != 0 {
+				token := os.Getenv("ADMIN_TOKEN")
+				if token == "" {
+					return errors.New("ADMIN_TOKEN environment variable must be set for the admin API")
 				}
 
-				r.Use(auth.URLTokenAuth(tokenMap))
-				fmt.Println("Authentication configured on gateway")
+				startAdminServer(admin.Config{Port: config.Admin.Port, Token: token}, gateways.Gateways, recorder)
 			}
 
 			server := &http.Server{
@@ -116,20 +144,20 @@ func main() {
 			defer server.Close()
 
 			var wg sync.WaitGroup
-			for _, gatewayConfig := range config.Gateways {
-				wg.Add(1)
-				go func(gwConfig GatewayConfig) {
-					defer wg.Done()
-					err := startGateway(c, gwConfig, r)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "error starting gateway '%s': %v\n", gwConfig.Name, err)
-					}
-				}(gatewayConfig)
-			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				gateways.Watch(c, cc.Duration("reload-interval"))
+
+				// Let in-flight requests complete before exiting.
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), server.WriteTimeout)
+				defer cancel()
+				server.Shutdown(shutdownCtx) // nolint:errcheck
+			}()
 
 			fmt.Printf("Starting RPC Gateway server on port: %d\n", config.Port)
 			err = server.ListenAndServe()
-			if err != nil {
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
 				return err
 			}
 
@@ -139,11 +167,36 @@ func main() {
 		},
 	}
 
+	app.Commands = []*cli.Command{
+		{
+			Name:      "hash-token",
+			Usage:     "Print the hash of a token for the token file. A random token is generated if none is given.",
+			ArgsUsage: "[token]",
+			Action:    hashToken,
+		},
+	}
+
 	if err := app.Run(os.Args); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v", err)
 	}
 }
 
+// newRouter returns the router with the middlewares every request goes
+// through. The health check is answered first, so that its path is not taken
+// for an API key. The API key, if extractKey is set, is taken out of the
+// request before it is logged.
+func newRouter(logger *httplog.Logger, extractKey func(http.Handler) http.Handler) *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(middleware.Heartbeat("/health"))
+	if extractKey != nil {
+		r.Use(extractKey)
+	}
+	r.Use(httplog.RequestLogger(logger))
+	r.Use(middleware.Recoverer)
+
+	return r
+}
+
 func resolveConfigPath(config string, isENV bool) string {
 	if isENV {
 		return "GATEWAY_CONFIG"
@@ -152,7 +205,66 @@ func resolveConfigPath(config string, isENV bool) string {
 	return config
 }
 
-func configureLogger() *httplog.Logger {
+// loadTokenStore returns the tokens of the token file, reloaded when it
+// changes, or the ones of GATEWAY_TOKEN_MAP when no file is given.
+func loadTokenStore(c context.Context, tokenFile string, reloadInterval time.Duration) (auth.TokenStore, error) {
+	if tokenFile != "" {
+		store, err := auth.NewFileStore(tokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load token file")
+		}
+
+		if reloadInterval > 0 {
+			go store.Watch(c, reloadInterval)
+		}
+
+		return store, nil
+	}
+
+	tokenMapJSON := os.Getenv("GATEWAY_TOKEN_MAP")
+	if tokenMapJSON == "" {
+		return nil, errors.New("GATEWAY_TOKEN_MAP environment variable or --token-file must be set for authentication")
+	}
+
+	var tokenMap map[string]auth.TokenInfo
+
+	if err := json.Unmarshal([]byte(tokenMapJSON), &tokenMap); err != nil {
+		return nil, errors.Wrap(err, "failed to parse GATEWAY_TOKEN_MAP")
+	}
+
+	for _, details := range tokenMap {
+		if details.NumOfRequestPerSec <= 0 {
+			return nil, errors.New("numOfRequestPerSec must be a positive number")
+		}
+		fmt.Printf("Configured limiter for %s, allowed %d requests per second\n",
+			details.Name, details.NumOfRequestPerSec,
+		)
+	}
+
+	return auth.StaticStore(tokenMap), nil
+}
+
+func hashToken(cc *cli.Context) error {
+	token := cc.Args().First()
+	if token == "" {
+		generated, err := auth.GenerateToken()
+		if err != nil {
+			return errors.Wrap(err, "failed to generate token")
+		}
+		token = generated
+		fmt.Printf("token: %s\n", token)
+	}
+
+	hash, err := auth.HashToken(token)
+	if err != nil {
+		return errors.Wrap(err, "failed to hash token")
+	}
+	fmt.Printf("hash: %s\n", hash)
+
+	return nil
+}
+
+func configureLogger(authConfig auth.Config) *httplog.Logger {
 	logLevel := slog.LevelWarn
 	if os.Getenv("DEBUG") == "true" {
 		logLevel = slog.LevelDebug
@@ -162,6 +274,9 @@ func configureLogger() *httplog.Logger {
 		JSON:           true,
 		RequestHeaders: true,
 		LogLevel:       logLevel,
+		// Keys are removed from requests before they are logged, this only
+		// guards against the middleware order changing.
+		HideRequestHeaders: []string{authConfig.HeaderName()},
 	})
 }
 
@@ -181,18 +296,20 @@ func startMetricsServer(port uint) {
 	}()
 }
 
-func startGateway(ctx context.Context, config GatewayConfig, router *chi.Mux) error {
-	service, err := rpcgateway.NewRPCGatewayFromConfigFile(config.ConfigFile, router)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("%s rpc-gateway failed", config.Name))
-	}
-
-	err = service.Start(ctx)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("cannot start %s rpc-gateway", config.Name))
-	}
-
-	<-ctx.Done()
+func startAdminServer(config admin.Config, gateways admin.GatewaysFunc, recorder *usage.Recorder) {
+	adminServer := admin.NewServer(config, gateways, recorder)
+	go func() {
+		err := adminServer.Start()
+		defer func(adminServer *admin.Server) {
+			err := adminServer.Stop()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error stopping admin server: %v\n", err)
+			}
+		}(adminServer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error starting admin server: %v\n", err)
+		}
+	}()
 
-	return errors.Wrap(service.Stop(ctx), fmt.Sprintf("cannot stop %s rpc-gateway", config.Name))
+	fmt.Printf("Starting admin API on port: %d\n", config.Port)
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/auth"
+)
+
+func TestRouterHealthWithAuth(t *testing.T) {
+	extractKey, err := auth.ExtractKey(auth.Config{})
+	assert.NoError(t, err)
+
+	r := newRouter(configureLogger(auth.Config{}), extractKey)
+	r.Use(auth.StoreAuth(auth.StaticStore{}, auth.CostConfig{}, nil))
+	r.Handle("/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the health check should be answered by the router")
+	}))
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Other requests still need a key.
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/mainnet", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"github.com/sygmaprotocol/rpc-gateway/internal/jsonrpc"
+	"github.com/sygmaprotocol/rpc-gateway/internal/rpcgateway"
+	"github.com/sygmaprotocol/rpc-gateway/internal/util"
+)
+
+// gatewaySet is one generation of gateways built from the configuration. It
+// has its own router, so that a new generation can be swapped in without
+// conflicting routes.
+type gatewaySet struct {
+	router   *chi.Mux
+	gateways []*rpcgateway.RPCGateway
+	// cancels stop the health checks of the running gateways.
+	cancels map[*rpcgateway.RPCGateway]context.CancelFunc
+}
+
+// newGatewaySet builds the gateways of the configuration. The gateways of
+// previous whose configuration did not change are carried over as they are,
+// the others inherit the runtime state of the providers they keep.
+func newGatewaySet(configs []GatewayConfig, previous *gatewaySet) (*gatewaySet, error) {
+	set := &gatewaySet{
+		router:  chi.NewRouter(),
+		cancels: make(map[*rpcgateway.RPCGateway]context.CancelFunc),
+	}
+
+	for _, config := range configs {
+		gatewayConfig, err := util.LoadJSONFile[rpcgateway.RPCGatewayConfig](config.ConfigFile)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("%s rpc-gateway failed to load config", config.Name))
+		}
+
+		running := previous.gateway(gatewayConfig.Name)
+		if running != nil && reflect.DeepEqual(running.Config(), *gatewayConfig) {
+			running.Register(set.router)
+			set.gateways = append(set.gateways, running)
+			set.cancels[running] = previous.cancels[running]
+
+			continue
+		}
+
+		fmt.Println("Starting RPC Gateway for " + gatewayConfig.Name + " on path: /" + gatewayConfig.Proxy.Path)
+
+		service, err := rpcgateway.NewRPCGateway(*gatewayConfig, set.router)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("%s rpc-gateway failed", config.Name))
+		}
+		if running != nil {
+			service.Inherit(running)
+		}
+
+		set.gateways = append(set.gateways, service)
+	}
+
+	return set, nil
+}
+
+// gateway returns the gateway with the given name, or nil if there is none.
+func (s *gatewaySet) gateway(name string) *rpcgateway.RPCGateway {
+	if s == nil {
+		return nil
+	}
+
+	for _, service := range s.gateways {
+		if service.Name() == name {
+			return service
+		}
+	}
+
+	return nil
+}
+
+// start starts the gateways that are not running yet.
+func (s *gatewaySet) start(ctx context.Context) {
+	for _, service := range s.gateways {
+		if _, running := s.cancels[service]; running {
+			continue
+		}
+
+		gatewayCtx, cancel := context.WithCancel(ctx)
+		s.cancels[service] = cancel
+
+		go func(service *rpcgateway.RPCGateway) {
+			if err := service.Start(gatewayCtx); err != nil {
+				fmt.Fprintf(os.Stderr, "cannot start rpc-gateway: %v\n", err)
+			}
+		}(service)
+	}
+}
+
+// stop stops the gateways, except the ones carried over to next, which may
+// be nil when the gateway shuts down. The metrics of the providers and
+// gateways next does not have are deleted.
+func (s *gatewaySet) stop(next *gatewaySet) {
+	for _, service := range s.gateways {
+		if next != nil && next.cancels[service] != nil {
+			continue
+		}
+
+		s.cancels[service]()
+		if err := service.Stop(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot stop rpc-gateway: %v\n", err)
+		}
+		if next != nil {
+			service.DeleteMetrics(next.gateway(service.Name()))
+		}
+	}
+}
+
+// gatewayManager serves the current gateway set and replaces it when the
+// configuration changes. Requests already dispatched to the previous set
+// complete normally, as its proxies stay usable after being swapped out.
+type gatewayManager struct {
+	configPath string
+	// started is the main configuration the process started with, whose
+	// settings outside of the gateways are not reloaded.
+	started  Config
+	current  atomic.Pointer[gatewaySet]
+	modTimes map[string]time.Time
+	// failedHash is the hash of the configuration files that last failed to
+	// load, which are not loaded again until they change.
+	failedHash string
+	mu         sync.Mutex
+}
+
+func newGatewayManager(configPath string, config *Config) *gatewayManager {
+	return &gatewayManager{
+		configPath: configPath,
+		started:    *config,
+	}
+}
+
+func (m *gatewayManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	set := m.current.Load()
+	if set == nil {
+		jsonrpc.WriteRequestError(w, r, http.StatusServiceUnavailable, jsonrpc.CodeNoUpstream, "gateways are not loaded")
+
+		return
+	}
+
+	set.router.ServeHTTP(w, r)
+}
+
+// Gateways returns the gateways currently served.
+func (m *gatewayManager) Gateways() []*rpcgateway.RPCGateway {
+	set := m.current.Load()
+	if set == nil {
+		return nil
+	}
+
+	return set.gateways
+}
+
+// Load builds the gateways from the configuration and swaps them in place of
+// the running ones. On error the running gateways are kept.
+func (m *gatewayManager) Load(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.load(ctx); err != nil {
+		m.failedHash = configHash(m.modTimes)
+
+		return err
+	}
+	m.failedHash = ""
+
+	return nil
+}
+
+func (m *gatewayManager) load(ctx context.Context) error {
+	config, err := util.LoadJSONFile[Config](m.configPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to load config")
+	}
+
+	m.warnRestartRequired(config)
+
+	running := m.current.Load()
+	set, err := newGatewaySet(config.Gateways, running)
+	if err != nil {
+		return err
+	}
+	m.restoreOperatorState(running, set)
+	set.start(ctx)
+
+	m.modTimes = configModTimes(m.configPath, config.Gateways)
+
+	if previous := m.current.Swap(set); previous != nil {
+		previous.stop(set)
+	}
+
+	return nil
+}
+
+// Watch reloads the gateways on SIGHUP and, if interval is not zero, when one
+// of the configuration files changes on disk. It returns when ctx is done,
+// stopping the running gateways.
+func (m *gatewayManager) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if set := m.current.Load(); set != nil {
+				set.stop(nil)
+			}
+
+			return
+		case <-hup:
+			m.reload(ctx, "SIGHUP received")
+		case <-tick:
+			if m.changed() {
+				m.reload(ctx, "configuration changed")
+			}
+		}
+	}
+}
+
+// warnRestartRequired logs the changes of the main configuration that only
+// apply once the process restarts.
+func (m *gatewayManager) warnRestartRequired(config *Config) {
+	if config.Port != m.started.Port {
+		fmt.Fprintf(os.Stderr, "port changed from %d to %d, restart required to apply it\n", m.started.Port, config.Port)
+	}
+
+	for _, section := range []struct {
+		name    string
+		changed bool
+	}{
+		{"metrics", !reflect.DeepEqual(config.Metrics, m.started.Metrics)},
+		{"admin", !reflect.DeepEqual(config.Admin, m.started.Admin)},
+		{"auth", !reflect.DeepEqual(config.Auth, m.started.Auth)},
+		{"usage", !reflect.DeepEqual(config.Usage, m.started.Usage)},
+	} {
+		if section.changed {
+			fmt.Fprintf(os.Stderr, "%s configuration changed, restart required to apply it\n", section.name)
+		}
+	}
+}
+
+// restoreOperatorState applies the changes made through the admin API to the
+// running gateways to the ones rebuilt in their place. Gateways carried over
+// keep them as they are.
+func (m *gatewayManager) restoreOperatorState(running, set *gatewaySet) {
+	for _, service := range set.gateways {
+		if previous := running.gateway(service.Name()); previous != nil && previous != service {
+			service.Proxy().RestoreOperatorState(previous.Proxy().OperatorState())
+		}
+	}
+}
+
+func (m *gatewayManager) reload(ctx context.Context, reason string) {
+	fmt.Printf("Reloading gateways: %s\n", reason)
+
+	if err := m.Load(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "error reloading gateways, keeping the running ones: %v\n", err)
+	}
+}
+
+func (m *gatewayManager) changed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for path, modTime := range m.modTimes {
+		current, ok := fileModTime(path)
+		if !ok || !current.Equal(modTime) {
+			// A configuration that failed to load is only loaded again
+			// once it changes, instead of failing on every check.
+			return m.failedHash == "" || configHash(m.modTimes) != m.failedHash
+		}
+	}
+
+	return false
+}
+
+// configHash returns the hash of the content of the watched configuration
+// files.
+func configHash(modTimes map[string]time.Time) string {
+	paths := make([]string, 0, len(modTimes))
+	for path := range modTimes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	hash := sha256.New()
+	for _, path := range paths {
+		// Missing files hash like empty ones; they fail to load either way.
+		content, _ := os.ReadFile(path)
+		fmt.Fprintf(hash, "%s\x00%d\x00", path, len(content))
+		hash.Write(content)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// configModTimes returns the modification times of the configuration files
+// that can be watched, i.e. local files.
+func configModTimes(configPath string, gateways []GatewayConfig) map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+
+	for _, path := range append([]string{configPath}, gatewayConfigFiles(gateways)...) {
+		if modTime, ok := fileModTime(path); ok {
+			modTimes[path] = modTime
+		}
+	}
+
+	return modTimes
+}
+
+func gatewayConfigFiles(gateways []GatewayConfig) []string {
+	files := make([]string, 0, len(gateways))
+	for _, gateway := range gateways {
+		files = append(files, gateway.ConfigFile)
+	}
+
+	return files
+}
+
+// fileModTime returns the modification time of a configuration file. Like
+// util.LoadJSONFile, environment variables and URLs take precedence over
+// files; those cannot be watched.
+func fileModTime(path string) (time.Time, bool) {
+	if _, isInENV := os.LookupEnv(path); isInENV || util.IsValidURL(path) {
+		return time.Time{}, false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return info.ModTime(), true
+}
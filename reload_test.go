@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/sygmaprotocol/rpc-gateway/internal/proxy"
+)
+
+func writeGatewayConfig(t *testing.T, path, name, url, timeout string) {
+	t.Helper()
+
+	config := fmt.Sprintf(`{
+  "name": %q,
+  "proxy": {"path": %q, "upstreamTimeout": %q},
+  "healthChecks": {"interval": "1s", "timeout": "1s"},
+  "targets": [
+    {"name": "A", "connection": {"http": {"url": %q}}},
+    {"name": "B", "connection": {"http": {"url": %q}}}
+  ]
+}`, name, name, timeout, url, url)
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+}
+
+func TestGatewayManagerReload(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	mainnetPath := filepath.Join(dir, "mainnet.json")
+	sepoliaPath := filepath.Join(dir, "sepolia.json")
+
+	writeGatewayConfig(t, mainnetPath, "mainnet", server.URL, "1s")
+	writeGatewayConfig(t, sepoliaPath, "sepolia", server.URL, "1s")
+	assert.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(
+		`{"gateways": [{"configFile": %q, "name": "mainnet"}, {"configFile": %q, "name": "sepolia"}]}`,
+		mainnetPath, sepoliaPath)), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := newGatewayManager(configPath, &Config{})
+	assert.NoError(t, manager.Load(ctx))
+	defer manager.current.Load().stop(nil)
+	before := manager.Gateways()
+
+	sepolia := before[1].Proxy()
+	assert.NoError(t, sepolia.SetPriority([]string{"B"}))
+	assert.NoError(t, sepolia.Cordon("A", true))
+	assert.NoError(t, sepolia.SetHealthOverride("B", proxy.HealthOverrideHealthy))
+
+	writeGatewayConfig(t, sepoliaPath, "sepolia", server.URL, "2s")
+	assert.NoError(t, manager.Load(ctx))
+	after := manager.Gateways()
+
+	// Only the gateway whose configuration changed is rebuilt.
+	assert.Same(t, before[0], after[0])
+	assert.NotSame(t, before[1], after[1])
+
+	// The changes made by operators are applied to the rebuilt gateway.
+	status := after[1].Proxy().Status()
+	assert.Equal(t, "B", status[0].Name)
+	assert.Equal(t, proxy.HealthOverrideHealthy, status[0].HealthOverride)
+	assert.Equal(t, "A", status[1].Name)
+	assert.True(t, status[1].Cordoned)
+
+	for _, path := range []string{"/mainnet", "/sepolia"} {
+		rr := httptest.NewRecorder()
+		manager.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path,
+			strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)))
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+	}
+}
+
+func TestGatewayManagerReloadDeletesMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	prometheus.DefaultRegisterer = registry
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	mainnetPath := filepath.Join(dir, "mainnet.json")
+	sepoliaPath := filepath.Join(dir, "sepolia.json")
+
+	writeGatewayConfig(t, mainnetPath, "mainnet", server.URL, "1s")
+	writeGatewayConfig(t, sepoliaPath, "sepolia", server.URL, "1s")
+	assert.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(
+		`{"gateways": [{"configFile": %q, "name": "mainnet"}, {"configFile": %q, "name": "sepolia"}]}`,
+		mainnetPath, sepoliaPath)), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := newGatewayManager(configPath, &Config{})
+	assert.NoError(t, manager.Load(ctx))
+	defer func() { manager.current.Load().stop(nil) }()
+
+	for _, path := range []string{"/mainnet", "/sepolia"} {
+		manager.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path,
+			strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`)))
+	}
+
+	// series returns the providers of the series of the metrics, by metric.
+	series := func() map[string][]string {
+		families, err := registry.Gather()
+		assert.NoError(t, err)
+
+		providers := make(map[string][]string)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "provider" {
+						providers[family.GetName()] = append(providers[family.GetName()], label.GetValue())
+					}
+				}
+			}
+		}
+
+		return providers
+	}
+	assert.Equal(t, []string{"A"}, series()["zeroex_rpc_gateway_request_duration_seconds_mainnet"])
+	assert.Equal(t, []string{"A"}, series()["zeroex_rpc_gateway_request_duration_seconds_sepolia"])
+
+	// Provider A is removed from sepolia and mainnet is removed.
+	assert.NoError(t, os.WriteFile(sepoliaPath, []byte(fmt.Sprintf(`{
+  "name": "sepolia",
+  "proxy": {"path": "sepolia", "upstreamTimeout": "1s"},
+  "healthChecks": {"interval": "1s", "timeout": "1s"},
+  "targets": [{"name": "B", "connection": {"http": {"url": %q}}}]
+}`, server.URL)), 0o600))
+	assert.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(
+		`{"gateways": [{"configFile": %q, "name": "sepolia"}]}`, sepoliaPath)), 0o600))
+	assert.NoError(t, manager.Load(ctx))
+
+	for name, providers := range series() {
+		assert.NotContains(t, name, "mainnet")
+		assert.NotContains(t, providers, "A", name)
+	}
+}
+
+func TestGatewayManagerSkipsFailedConfig(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	sepoliaPath := filepath.Join(dir, "sepolia.json")
+
+	writeGatewayConfig(t, sepoliaPath, "sepolia", "http://localhost:8545", "1s")
+	assert.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(
+		`{"gateways": [{"configFile": %q, "name": "sepolia"}]}`, sepoliaPath)), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := newGatewayManager(configPath, &Config{})
+	assert.NoError(t, manager.Load(ctx))
+	defer func() { manager.current.Load().stop(nil) }()
+
+	touch := func(content string, modTime time.Time) {
+		assert.NoError(t, os.WriteFile(sepoliaPath, []byte(content), 0o600))
+		assert.NoError(t, os.Chtimes(sepoliaPath, modTime, modTime))
+	}
+
+	touch(`{"name": `, time.Now().Add(time.Minute))
+	assert.True(t, manager.changed())
+	assert.Error(t, manager.Load(ctx))
+
+	// The broken configuration is not loaded again until it changes.
+	assert.False(t, manager.changed())
+	touch(`{"name": `, time.Now().Add(2*time.Minute))
+	assert.False(t, manager.changed())
+	touch(`{"name": "sepolia"`, time.Now().Add(3*time.Minute))
+	assert.True(t, manager.changed())
+}